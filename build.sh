@@ -1,2 +1,5 @@
 #!/bin/bash -e
-docker build -t ${1:-'dabealu/zookeeper-exporter:latest'} .
\ No newline at end of file
+docker build \
+    --build-arg VERSION=$(git describe --tags --always 2>/dev/null || echo dev) \
+    --build-arg COMMIT=$(git rev-parse HEAD 2>/dev/null || echo unknown) \
+    -t ${1:-'dabealu/zookeeper-exporter:latest'} .
@@ -0,0 +1,154 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+const adminServerCommandsPath = "/commands/"
+
+// errAdminAuth is returned when AdminServer rejects credentials
+var errAdminAuth = errors.New("authentication failed")
+
+// adminResponse is a response of AdminServer command, e.g. '/commands/monitor';
+// fields of 'monitor' response are the same as 'mntr' keys without 'zk_' prefix
+type adminResponse map[string]interface{}
+
+// fetch 'monitor' and 'ruok' commands from zk AdminServer and add results to metrics,
+// translating them to the same metrics as produced by 'mntr' and 'ruok' 4lw commands;
+// h is zk server as in logs, adminAddr is 'host:port' of its AdminServer, which is
+// scraped over https if tlsConfig is set
+func scrapeAdminServer(ctx context.Context, options *Options, h, adminAddr string, tlsConfig *tls.Config, hostLabels []label, metrics *metricSet) {
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	defer transport.CloseIdleConnections()
+	if options.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(options.ProxyURL)
+	}
+	client := &http.Client{Timeout: options.Timeout, Transport: transport}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s%s", scheme, adminAddr, adminServerCommandsPath)
+
+	monitor, err := getAdminCommand(ctx, options, client, baseURL+"monitor")
+	// canceled scrape doesn't tell whether server is up
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		options.countError(hostLabels, "monitor")
+		options.log.Warn("cannot get 'monitor' from AdminServer", "zk_host", h, "error", err)
+		// http client errors are network errors, unlike bad responses of reachable
+		// server; server which rejects credentials can't be scraped at all, so it's
+		// down the same as unreachable one
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			addHostDown(metrics, options, h, hostLabels, errorReason(&connError{err}))
+			return
+		}
+		if errors.Is(err, errAdminAuth) {
+			addHostDown(metrics, options, h, hostLabels, downReasonAuth)
+			return
+		}
+		metrics.add("zk_up", hostLabels, "1")
+		metrics.add("zk_mntr_scrape_success", hostLabels, "0")
+		addDownReason(metrics, hostLabels, errorReason(err))
+		return
+	}
+
+	for key, value := range monitor {
+		if key == "command" || key == "error" {
+			continue
+		}
+
+		switch v := value.(type) {
+		case json.Number:
+			addMntrMetric(options, "zk_"+key, v.String(), hostLabels, metrics)
+		case string:
+			addMntrMetric(options, "zk_"+key, v, hostLabels, metrics)
+		case bool:
+			if v {
+				addMntrMetric(options, "zk_"+key, "1", hostLabels, metrics)
+			} else {
+				addMntrMetric(options, "zk_"+key, "0", hostLabels, metrics)
+			}
+		}
+	}
+
+	// successful 'monitor' proves server is responsive, the same as 'mntr'
+	if options.AssumeRuok && !options.SkipRuok {
+		metrics.add("zk_ruok", hostLabels, "1")
+	} else if !options.SkipRuok {
+		if _, err := getAdminCommand(ctx, options, client, baseURL+"ruok"); err == nil {
+			metrics.add("zk_ruok", hostLabels, "1")
+		} else {
+			options.countError(hostLabels, "ruok")
+			addRuokFailure(metrics, hostLabels, errorReason(err))
+		}
+	}
+
+	metrics.add("zk_up", hostLabels, "1")
+	metrics.add("zk_mntr_scrape_success", hostLabels, "1")
+}
+
+// get AdminServer command, non-200 status or non-empty 'error' field are treated as errors
+func getAdminCommand(ctx context.Context, options *Options, client *http.Client, url string) (adminResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// AdminServer (zk 3.9+) authenticates digest users with 'digest user:password'
+	// auth scheme, it's not SASL DIGEST-MD5, which 4lw commands don't support at all
+	if options.AdminUser != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("digest %s:%s", options.AdminUser, options.AdminPassword))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w, status code %d", errAdminAuth, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	// read one byte over the limit to tell apart response of exactly max size,
+	// truncated response would fail to decode with misleading error
+	var body io.Reader = resp.Body
+	if options.MaxResponseSize > 0 {
+		body = io.LimitReader(resp.Body, options.MaxResponseSize+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response: %w", err)
+	}
+	if options.MaxResponseSize > 0 && int64(len(data)) > options.MaxResponseSize {
+		return nil, fmt.Errorf("%w of %d bytes", errResponseTooLarge, options.MaxResponseSize)
+	}
+
+	res := adminResponse{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&res); err != nil {
+		return nil, fmt.Errorf("cannot decode response: %s", err)
+	}
+
+	if e, ok := res["error"]; ok && e != nil {
+		return nil, fmt.Errorf("command returned error: %v", e)
+	}
+
+	return res, nil
+}
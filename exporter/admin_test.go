@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetAdminCommandMaxResponseSize(t *testing.T) {
+	body := `{"command":"monitor","error":null,"znode_count":42}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		limit    int64
+		tooLarge bool
+	}{
+		{0, false},
+		{int64(len(body)), false},
+		{int64(len(body)) - 1, true},
+	}
+	for _, tt := range tests {
+		options := &Options{MaxResponseSize: tt.limit}
+		res, err := getAdminCommand(context.Background(), options, server.Client(), server.URL)
+		if tt.tooLarge {
+			if !errors.Is(err, errResponseTooLarge) {
+				t.Errorf("limit %d: error = %v, want %v", tt.limit, err, errResponseTooLarge)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("limit %d: unexpected error: %v", tt.limit, err)
+			continue
+		}
+		if fmt.Sprint(res["znode_count"]) != "42" {
+			t.Errorf("limit %d: znode_count = %v, want 42", tt.limit, res["znode_count"])
+		}
+	}
+}
+
+func TestScrapeAdminServerHTTPS(t *testing.T) {
+	var auth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if strings.HasSuffix(r.URL.Path, "/monitor") {
+			fmt.Fprint(w, `{"command":"monitor","error":null,"server_state":"follower","znode_count":42}`)
+			return
+		}
+		fmt.Fprint(w, `{"command":"ruok","error":null}`)
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	addr := strings.TrimPrefix(server.URL, "https://")
+	options := &Options{Timeout: 5 * time.Second, AdminUser: "monitor", AdminPassword: "secret"}
+	hostLabels := []label{{"zk_host", addr}}
+	metrics := newMetricSet()
+	scrapeAdminServer(context.Background(), options, addr, addr, hostTLSConfig(&tls.Config{RootCAs: roots}, addr), hostLabels, metrics)
+
+	if v, _ := metrics.value("zk_up", hostLabels); v != "1" {
+		t.Errorf("zk_up = %q, want 1", v)
+	}
+	if v, _ := metrics.value("zk_znode_count", hostLabels); v != "42" {
+		t.Errorf("zk_znode_count = %q, want 42", v)
+	}
+	if auth != "digest monitor:secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "digest monitor:secret")
+	}
+}
+
+func TestScrapeAdminServerAuthRejected(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "auth failed", status)
+		}))
+		addr := strings.TrimPrefix(server.URL, "http://")
+		options := newTestOptions()
+		options.Timeout, options.AdminUser, options.AdminPassword, options.AdminPort = 5*time.Second, "monitor", "wrong", 8080
+		hostLabels := []label{{"zk_host", addr}}
+		metrics := newMetricSet()
+		scrapeAdminServer(context.Background(), options, addr, addr, nil, hostLabels, metrics)
+		server.Close()
+
+		if v, _ := metrics.value("zk_up", hostLabels); v != "0" {
+			t.Errorf("status %d: zk_up = %q, want 0", status, v)
+		}
+		if v, _ := metrics.value("zk_mntr_scrape_success", hostLabels); v != "0" {
+			t.Errorf("status %d: zk_mntr_scrape_success = %q, want 0", status, v)
+		}
+		if _, ok := metrics.value("zk_connection_error", append(hostLabels, label{"reason", downReasonAuth})); !ok {
+			t.Errorf("status %d: zk_connection_error with reason=%q isn't added", status, downReasonAuth)
+		}
+	}
+}
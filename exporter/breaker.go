@@ -0,0 +1,87 @@
+package exporter
+
+import "sync"
+
+// circuitBreaker tracks consecutive failed scrapes of zk servers; once server failed
+// -breaker-failures times in a row, its breaker opens: server is reported down without
+// connecting to it and probed only every -breaker-probe-every scrapes, until it's up again
+type circuitBreaker struct {
+	mu    sync.Mutex
+	hosts map[string]*breakerState
+}
+
+type breakerState struct {
+	failures int // consecutive failed scrapes
+	skipped  int // scrapes skipped since the last probe
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{hosts: map[string]*breakerState{}}
+}
+
+// allow reports whether zk server should be scraped, key identifies server across scrapes
+func (b *circuitBreaker) allow(options *Options, key string) bool {
+	if options.BreakerFailures <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.hosts[key]
+	if !ok || s.failures < options.BreakerFailures {
+		return true
+	}
+	s.skipped++
+	if s.skipped >= options.BreakerProbe {
+		s.skipped = 0
+		return true
+	}
+	return false
+}
+
+// record updates state of zk server after scrape, successful scrape closes breaker
+func (b *circuitBreaker) record(key string, up bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if up {
+		delete(b.hosts, key)
+		return
+	}
+	s, ok := b.hosts[key]
+	if !ok {
+		s = &breakerState{}
+		b.hosts[key] = s
+	}
+	s.failures++
+}
+
+// isOpen reports whether zk server is skipped between probes
+func (b *circuitBreaker) isOpen(options *Options, key string) bool {
+	if options.BreakerFailures <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.hosts[key]
+	return ok && s.failures >= options.BreakerFailures
+}
+
+// keys of zk servers with failed scrapes
+func (b *circuitBreaker) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.hosts))
+	for key := range b.hosts {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// forget state of zk server, e.g. once it's no longer scraped
+func (b *circuitBreaker) forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.hosts, key)
+}
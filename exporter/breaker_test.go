@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// address of zk server which is down until it's listened at
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	release := make(chan struct{})
+	close(release)
+	options := newTestOptions()
+	options.Hosts, options.Commands, options.Timeout = []string{addr}, []string{"mntr"}, 5*time.Second
+	options.BreakerFailures, options.BreakerProbe = 2, 3
+	hostLabels := options.hostLabels(addr)
+
+	// breaker opens after 2 failed scrapes, then server is probed every 3rd scrape
+	steps := []struct {
+		name      string
+		listening bool
+		connected bool // whether exporter connected to listening server
+		up        string
+		reason    string // reason of zk_connection_error, empty if server is up
+		open      string
+	}{
+		{name: "first failure", up: "0", reason: downReasonConnect, open: "0"},
+		{name: "breaker opens", up: "0", reason: downReasonConnect, open: "1"},
+		{name: "skipped", up: "0", reason: downReasonBreakerOpen, open: "1"},
+		{name: "skipped", up: "0", reason: downReasonBreakerOpen, open: "1"},
+		{name: "failed probe", up: "0", reason: downReasonConnect, open: "1"},
+		{name: "skipped while up", listening: true, up: "0", reason: downReasonBreakerOpen, open: "1"},
+		{name: "skipped while up", listening: true, up: "0", reason: downReasonBreakerOpen, open: "1"},
+		{name: "probe closes breaker", listening: true, connected: true, up: "1", open: "0"},
+		{name: "closed", listening: true, connected: true, up: "1", open: "0"},
+	}
+	var z *fakeZookeeper
+	defer func() {
+		if z != nil {
+			z.listener.Close()
+		}
+	}()
+	for i, step := range steps {
+		if step.listening && z == nil {
+			z = newFakeZookeeperAt(t, addr, release)
+		}
+		var conns int32
+		if z != nil {
+			conns = atomic.LoadInt32(&z.conns)
+		}
+
+		metrics := getMetrics(context.Background(), options)
+
+		if v, _ := metrics.value("zk_up", hostLabels); v != step.up {
+			t.Errorf("scrape %d, %s: zk_up = %q, want %q", i+1, step.name, v, step.up)
+		}
+		if v, _ := metrics.value("zk_exporter_breaker_open", hostLabels); v != step.open {
+			t.Errorf("scrape %d, %s: zk_exporter_breaker_open = %q, want %q", i+1, step.name, v, step.open)
+		}
+		for _, reason := range []string{downReasonConnect, downReasonBreakerOpen} {
+			_, ok := metrics.value("zk_connection_error", append(hostLabels, label{"reason", reason}))
+			if want := reason == step.reason; ok != want {
+				t.Errorf("scrape %d, %s: zk_connection_error with reason=%q added: %v, want %v", i+1, step.name, reason, ok, want)
+			}
+		}
+		if z != nil {
+			if connected := atomic.LoadInt32(&z.conns) > conns; connected != step.connected {
+				t.Errorf("scrape %d, %s: connected: %v, want %v", i+1, step.name, connected, step.connected)
+			}
+		}
+	}
+}
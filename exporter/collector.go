@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaults of -timeout and -commands, applied to zero Options by New
+	defaultTimeout  = 30 * time.Second
+	defaultCommands = "mntr,ruok"
+)
+
+// Exporter scrapes zk servers and exports their metrics as prometheus.Collector,
+// so that it can be registered in registry of another program
+type Exporter struct {
+	options *Options
+	scraper *scraper
+
+	// stops background scrapes, see Close
+	stop context.CancelFunc
+}
+
+// New returns Exporter which scrapes zk servers of options; hosts may have scheme,
+// e.g. 'tls://10.0.0.1:2281', zero Timeout and Commands get defaults of the
+// standalone exporter, negative Timeout is rejected. Clusters get the same
+// defaults, except that zero Timeout and Commands of cluster are inherited from
+// options. Options must not be modified afterwards, except hosts, which can be
+// replaced with Options.SetHosts. If ScrapeInterval is set, zk servers are scraped
+// in background until Close, otherwise on each Collect
+func New(options *Options) (*Exporter, error) {
+	if len(options.Hosts) == 0 && len(options.Clusters) == 0 && options.ProbeLocation == "" {
+		return nil, errors.New("no zookeeper hosts specified")
+	}
+	if err := options.initState(); err != nil {
+		return nil, err
+	}
+	if err := options.setDefaults(nil); err != nil {
+		return nil, err
+	}
+	for _, c := range options.Clusters {
+		if err := c.setDefaults(options); err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", c.Cluster, err)
+		}
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	e := &Exporter{options: options, scraper: newScraper(options), stop: stop}
+	if options.ScrapeInterval > 0 {
+		go e.scraper.run(ctx, options.ScrapeInterval)
+	}
+	return e, nil
+}
+
+// validate options and apply defaults of the standalone exporter, zero Timeout and
+// Commands of cluster are inherited from parent; hosts are stripped of schemes and
+// normalized, so that clusters built from -config file go through it unchanged
+func (o *Options) setDefaults(parent *Options) error {
+	if o.BreakerFailures > 0 && o.BreakerProbe < 1 {
+		return errors.New("BreakerProbe must be at least 1 if BreakerFailures is set")
+	}
+	if o.Timeout < 0 {
+		return errors.New("Timeout must not be negative")
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+		if parent != nil {
+			o.Timeout = parent.Timeout
+		}
+	}
+	if len(o.Commands) == 0 && parent != nil {
+		o.Commands = parent.Commands
+	}
+	cmds, err := parseCommands(strings.Join(o.Commands, ","))
+	if len(o.Commands) == 0 {
+		cmds, err = parseCommands(defaultCommands)
+	}
+	if err != nil {
+		return err
+	}
+	o.Commands = cmds
+
+	// 'tls://' hosts use TLSConfig, without client certificate if it isn't set,
+	// hosts of cluster without TLSConfig use the same tls config as of parent
+	if o.SchemeTLS == nil {
+		o.SchemeTLS = o.TLSConfig
+		if o.SchemeTLS == nil && parent != nil {
+			o.SchemeTLS = parent.SchemeTLS
+		}
+		if o.SchemeTLS == nil {
+			o.SchemeTLS = &tls.Config{MinVersion: o.TLSMinVersion, CipherSuites: o.TLSCipherSuites}
+		}
+	}
+	hosts, hostTLSConfigs, adminHosts, err := splitHostSchemes(o.GetHosts(), o.SchemeTLS)
+	if err != nil {
+		return err
+	}
+	// hosts of standalone exporter are already stripped of schemes;
+	// tls settings of host take precedence over its scheme
+	if hostTLSConfigs != nil {
+		for h, config := range o.HostTLSConfigs {
+			hostTLSConfigs[h] = config
+		}
+		o.SetHostTLSConfigs(hostTLSConfigs)
+	}
+	if adminHosts != nil {
+		o.SetAdminHosts(adminHosts)
+	}
+	o.SetHosts(normalizeHosts(hosts, o.log))
+	return nil
+}
+
+// Close stops background scrapes and aborts the one in progress, metrics of the
+// last finished scrape are still collected; it's a no-op without ScrapeInterval
+func (e *Exporter) Close() {
+	e.stop()
+}
+
+// Describe sends nothing: metrics of zk servers are known only after scrape,
+// so Exporter is an unchecked collector
+func (e *Exporter) Describe(chan<- *prometheus.Desc) {}
+
+// Collect scrapes zk servers, or takes the last background scrape, and sends its
+// metrics along with exporter metrics which persist across scrapes; the latter
+// belong to this Exporter, the same as its circuit breaker and logger. MetricPrefix
+// and Timestamps apply only to metrics served by the standalone exporter,
+// prometheus.WrapRegistererWithPrefix does the same for registry of embedding program
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.scraper.metrics(context.Background()).Collect(ch)
+	e.options.state.Collect(ch)
+}
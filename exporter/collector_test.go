@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExporterCollect(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+	host := z.listener.Addr().String()
+
+	e, err := New(&Options{Hosts: []string{"tcp://" + host}, Commands: []string{"mntr"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if e.options.Timeout != defaultTimeout {
+		t.Errorf("timeout %v, want default %v", e.options.Timeout, defaultTimeout)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	values := map[string]float64{}
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+		for _, m := range f.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "zk_host" && l.GetValue() == host {
+					values[f.GetName()] = m.GetGauge().GetValue() + m.GetUntyped().GetValue()
+				}
+			}
+		}
+	}
+	if values["zk_up"] != 1 {
+		t.Errorf("zk_up of %s = %v, want 1", host, values["zk_up"])
+	}
+	if values["zk_znode_count"] != 42 {
+		t.Errorf("zk_znode_count of %s = %v, want 42", host, values["zk_znode_count"])
+	}
+	// exporter metrics which persist across scrapes are collected along with scrape
+	for _, name := range []string{"zk_exporter_scrape_panics_total", "zk_exporter_command_duration_seconds"} {
+		if !names[name] {
+			t.Errorf("%s isn't collected", name)
+		}
+	}
+}
+
+func TestExporterCollectClusters(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+	host := z.listener.Addr().String()
+
+	filter, err := NewMetricFilter("", "zk_znode_count")
+	if err != nil {
+		t.Fatalf("NewMetricFilter: %v", err)
+	}
+	cluster := &Options{Cluster: "prod", Hosts: []string{"tcp://" + host}}
+	e, err := New(&Options{Clusters: []*Options{cluster}, Commands: []string{"mntr"}, MetricFilter: filter})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if cluster.Timeout != defaultTimeout {
+		t.Errorf("cluster timeout %v, want default %v", cluster.Timeout, defaultTimeout)
+	}
+	if len(cluster.Commands) != 1 || cluster.Commands[0] != "mntr" {
+		t.Errorf("cluster commands %v, want inherited [mntr]", cluster.Commands)
+	}
+	if got := cluster.GetHosts(); len(got) != 1 || got[0] != host {
+		t.Errorf("cluster hosts %v, want [%s]", got, host)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	values := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.Metric {
+			labels := map[string]string{}
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["cluster"] == "prod" && labels["zk_host"] == host {
+				values[f.GetName()] = m.GetGauge().GetValue() + m.GetUntyped().GetValue()
+			}
+		}
+	}
+	if values["zk_up"] != 1 {
+		t.Errorf("zk_up of %s in prod = %v, want 1", host, values["zk_up"])
+	}
+	if _, ok := values["zk_znode_count"]; ok {
+		t.Errorf("zk_znode_count of %s is exported, want it denied by filter", host)
+	}
+}
+
+func TestExporterCloseStopsScrapes(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+
+	interval := 10 * time.Millisecond
+	e, err := New(&Options{Hosts: []string{z.listener.Addr().String()}, Commands: []string{"mntr"}, ScrapeInterval: interval})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&z.conns) < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d background scrapes, want at least 3", atomic.LoadInt32(&z.conns))
+		}
+	}
+	e.Close()
+	// let the scrape in progress, if any, finish
+	time.Sleep(5 * interval)
+	closed := atomic.LoadInt32(&z.conns)
+	time.Sleep(10 * interval)
+	if n := atomic.LoadInt32(&z.conns); n != closed {
+		t.Errorf("%d scrapes after Close, want none", n-closed)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+	}{
+		{"no hosts", &Options{}},
+		{"unsupported command", &Options{Hosts: []string{"10.0.0.1:2181"}, Commands: []string{"kill"}}},
+		{"invalid scheme", &Options{Hosts: []string{"udp://10.0.0.1:2181"}}},
+		{"breaker without probes", &Options{Hosts: []string{"10.0.0.1:2181"}, BreakerFailures: 3}},
+		{"negative timeout", &Options{Hosts: []string{"10.0.0.1:2181"}, Timeout: -time.Second}},
+		{"invalid scheme of cluster", &Options{Clusters: []*Options{{Hosts: []string{"udp://10.0.0.1:2181"}}}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.options); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	options := &Options{Hosts: []string{"10.0.0.1"}, Timeout: time.Second}
+	if _, err := New(options); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := options.GetHosts(); len(got) != 1 || got[0] != "10.0.0.1:2181" {
+		t.Errorf("hosts %v, want [10.0.0.1:2181]", got)
+	}
+	if len(options.Commands) != 2 || options.Commands[0] != "mntr" || options.Commands[1] != "ruok" {
+		t.Errorf("commands %v, want default [mntr ruok]", options.Commands)
+	}
+}
@@ -0,0 +1,474 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// commandParser adds metrics parsed from 4lw command response,
+// error is returned if response or its part can't be parsed
+type commandParser func(options *Options, res string, hostLabels []label, metrics *metricSet) error
+
+// supported 4lw commands and their parsers
+var commandParsers = map[string]commandParser{
+	"mntr": parseMntr,
+	"ruok": parseRuok,
+	"srvr": parseSrvr,
+	"stat": parseSrvr,
+	"conf": parseConf,
+	"wchs": parseWchs,
+	"isro": parseIsro,
+	"dirs": parseDirs,
+	"cons": parseCons,
+}
+
+var (
+	wchsConnectionsRE = regexp.MustCompile(`^(\d+) connections watching (\d+) paths$`)
+	wchsTotalRE       = regexp.MustCompile(`^Total watches:\s*(\d+)$`)
+	camelCaseRE       = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	consConnectionRE  = regexp.MustCompile(`^\S+\[\d+\]\((.*)\)$`)
+)
+
+func supportedCommands() []string {
+	commands := make([]string, 0, len(commandParsers))
+	for cmd := range commandParsers {
+		commands = append(commands, cmd)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// metrics reported by 'dirs' command
+var dirsMetrics = map[string]string{
+	"datadir_size": "zk_datadir_size_bytes",
+	"logdir_size":  "zk_logdir_size_bytes",
+}
+
+// results of probing 4lw command, see probeCommands
+const (
+	probeOK          = "ok"
+	probeDenied      = "denied"
+	probeNotServing  = "not_serving"
+	probeFailed      = "failed"
+	probeUnreachable = "unreachable"
+)
+
+// try every supported 4lw command on every configured zk server and print table
+// of results, e.g. to find out which commands are whitelisted; returns false if
+// none of zk servers is reachable
+func probeCommands(w io.Writer, options *Options) bool {
+	type row struct {
+		host    string
+		results []string
+	}
+
+	var rows []*row
+	var wg sync.WaitGroup
+	clusters := options.Clusters
+	if len(clusters) == 0 {
+		clusters = []*Options{options}
+	}
+	for _, c := range clusters {
+		for _, h := range c.GetHosts() {
+			r := &row{host: h}
+			if c.Cluster != "" {
+				r.host = c.Cluster + "/" + h
+			}
+			rows = append(rows, r)
+
+			wg.Add(1)
+			go func(c *Options, h string, r *row) {
+				defer wg.Done()
+				r.results = probeHost(c, h)
+			}(c, h, r)
+		}
+	}
+	wg.Wait()
+
+	reachable := false
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "HOST\t%s\n", strings.Join(supportedCommands(), "\t"))
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", r.host, strings.Join(r.results, "\t"))
+		if r.results[0] != probeUnreachable {
+			reachable = true
+		}
+	}
+	tw.Flush()
+	return reachable
+}
+
+// execute every supported command on zk server, returns result per command
+func probeHost(options *Options, h string) []string {
+	network, addr := "tcp", h
+	if path, ok := unixSocketPath(h); ok {
+		network, addr = "unix", path
+	}
+	tlsConfig := hostTLSConfig(options.hostTLS(h), h)
+
+	var results []string
+	for _, cmd := range supportedCommands() {
+		res, _, err := execZookeeperCmd(context.Background(), options, network, addr, h, cmd, tlsConfig)
+		switch {
+		case err != nil:
+			// server is down, other commands won't succeed either
+			var ce *connError
+			if errors.As(err, &ce) {
+				options.log.Warn("cannot connect", "zk_host", h, "error", err)
+				for len(results) < len(commandParsers) {
+					results = append(results, probeUnreachable)
+				}
+				return results
+			}
+			options.log.Warn("command failed", "zk_host", h, "command", cmd, "error", err)
+			results = append(results, probeFailed)
+		case strings.Contains(res, cmdNotExecutedSffx):
+			results = append(results, probeDenied)
+		case strings.HasPrefix(res, instanceNotServingMessage):
+			results = append(results, probeNotServing)
+		default:
+			results = append(results, probeOK)
+		}
+	}
+	return results
+}
+
+// write raw responses of zk server to commands, each preceded by '### <command>' line,
+// e.g. to see what exactly exporter parses when metrics look wrong
+func writeResponses(ctx context.Context, w io.Writer, options *Options, h string, commands []string) {
+	network, addr := "tcp", h
+	if path, ok := unixSocketPath(h); ok {
+		network, addr = "unix", path
+	}
+	tlsConfig := hostTLSConfig(options.hostTLS(h), h)
+
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "### %s\n", cmd)
+		res, _, err := execZookeeperCmd(ctx, options, network, addr, h, cmd, tlsConfig)
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+			continue
+		}
+		io.WriteString(w, res)
+		if !strings.HasSuffix(res, "\n") {
+			io.WriteString(w, "\n")
+		}
+	}
+}
+
+// parse comma separated list of 4lw commands, unknown commands are rejected
+func parseCommands(list string) ([]string, error) {
+	var commands []string
+	for _, cmd := range strings.Split(list, ",") {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" || containsString(commands, cmd) {
+			continue
+		}
+		if _, ok := commandParsers[cmd]; !ok {
+			return nil, fmt.Errorf("unsupported command %q, supported: %s", cmd, strings.Join(supportedCommands(), ","))
+		}
+		commands = append(commands, cmd)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("no commands specified")
+	}
+	return commands, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parse 'mntr' response, tab separated key-value pairs like 'zk_avg_latency	0'
+func parseMntr(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	for _, l := range strings.Split(res, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		// key is separated from value by any run of tabs and spaces, value may contain
+		// spaces, e.g. 'zk_version	3.6.3--6401e4ad, built on 04/08/2021 16:35 GMT',
+		// and so may label values of key, e.g. 'zk_write_per_namespace{key="my ns"}	3'
+		end := strings.IndexAny(l, " \t")
+		if brace := strings.Index(l, "{"); brace >= 0 && (end < 0 || brace < end) {
+			if closing := strings.LastIndex(l, "}"); closing > brace {
+				end = strings.IndexAny(l[closing:], " \t")
+				if end >= 0 {
+					end += closing
+				}
+			}
+		}
+		// key without value, e.g. response truncated on shutdown, is skipped,
+		// the rest of response is still valid
+		if end < 0 {
+			options.log.Warn("skipping mntr line without value", "zk_host", labelValue(hostLabels, "zk_host"), "line", l)
+			continue
+		}
+		addMntrMetric(options, l[:end], strings.TrimSpace(l[end:]), hostLabels, metrics)
+	}
+	return nil
+}
+
+// parse 'ruok' response, which is 'imok' if server is running in non-error state
+func parseRuok(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	if res == "imok" {
+		metrics.add("zk_ruok", hostLabels, "1")
+	} else {
+		addRuokFailure(metrics, hostLabels, ruokReasonNotImok)
+	}
+	return nil
+}
+
+// parse 'isro' response, which is 'ro' in read-only mode and 'rw' otherwise;
+// metric is omitted on any other response
+func parseIsro(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	switch strings.TrimSpace(res) {
+	case "ro":
+		metrics.add("zk_read_only", hostLabels, "1")
+	case "rw":
+		metrics.add("zk_read_only", hostLabels, "0")
+	default:
+		return fmt.Errorf("unexpected 'isro' response %q", res)
+	}
+	return nil
+}
+
+// parse 'wchs' response like:
+//
+//	2 connections watching 5 paths
+//	Total watches:7
+func parseWchs(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	for _, l := range strings.Split(res, "\n") {
+		l = strings.TrimSpace(l)
+		if m := wchsConnectionsRE.FindStringSubmatch(l); m != nil {
+			metrics.add("zk_watch_connections", hostLabels, m[1])
+			metrics.add("zk_watch_paths", hostLabels, m[2])
+		} else if m := wchsTotalRE.FindStringSubmatch(l); m != nil {
+			metrics.add("zk_watch_count", hostLabels, m[1])
+		}
+	}
+	return nil
+}
+
+// parse 'conf' response, 'key=value' lines; numeric parameters are exported
+// with snake cased names, e.g. 'tickTime=2000' becomes 'zk_conf_tick_time 2000';
+// voting members listed as 'server.1=zk-0:2888:3888:participant;2181' are counted
+// as quorum size, observers are skipped; hex 'version' of dynamic config is
+// exported as decimal
+func parseConf(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	quorum, ensemble := 0, false
+	for _, l := range strings.Split(res, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(l), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if strings.HasPrefix(kv[0], "server.") {
+			ensemble = true
+			if !strings.Contains(kv[1], ":observer") {
+				quorum++
+			}
+			continue
+		}
+		// version of dynamic config is hex, e.g. 'version=10000000a'
+		if kv[0] == "version" {
+			if v, err := strconv.ParseUint(kv[1], 16, 64); err == nil {
+				metrics.add("zk_conf_version", hostLabels, strconv.FormatUint(v, 10))
+			}
+			continue
+		}
+		value, ok := parseNumber(kv[1])
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(camelCaseRE.ReplaceAllString(kv[0], "${1}_${2}"))
+		metrics.add("zk_conf_"+name, hostLabels, value)
+	}
+	// standalone server doesn't list members
+	if ensemble {
+		metrics.add("zk_conf_quorum_size", hostLabels, strconv.Itoa(quorum))
+	}
+	return nil
+}
+
+// metrics reported by 'srvr' command, mapped to names of corresponding 'mntr' metrics
+var srvrMetrics = map[string]string{
+	"Received":    "zk_packets_received",
+	"Sent":        "zk_packets_sent",
+	"Connections": "zk_num_alive_connections",
+	"Outstanding": "zk_outstanding_requests",
+	"Node count":  "zk_znode_count",
+}
+
+// parse 'srvr' response, which is a free-form text like:
+//
+//	Zookeeper version: 3.6.3--6401e4ad2087061bc6b9f80dec2d69f2e3c8660a, built on 04/08/2021 16:35 GMT
+//	Latency min/avg/max: 0/0.5/10
+//	Received: 100
+//	Sent: 99
+//	Connections: 3
+//	Outstanding: 0
+//	Zxid: 0x100000002
+//	Mode: leader
+//	Node count: 42
+//
+// 'stat' response has the same format plus indented list of clients, which is skipped;
+// numbers which 'mntr' of the same scrape already reported are kept, e.g. with
+// '-commands mntr,srvr', since 'mntr' reports them more precisely
+func parseSrvr(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	addNumber := func(name, value string) {
+		if _, ok := metrics.value(name, hostLabels); !ok {
+			addMntrMetric(options, name, value, hostLabels, metrics)
+		}
+	}
+
+	var err error
+	for _, l := range strings.Split(res, "\n") {
+		// skip empty lines, 'Clients:' header and indented client lines of 'stat'
+		if l == "" || strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") {
+			continue
+		}
+
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch key {
+		case "Zookeeper version":
+			addMntrMetric(options, "zk_version", value, hostLabels, metrics)
+
+		case "Mode":
+			metrics.add("zk_server_mode", append(hostLabels, label{"mode", value}), "1")
+			addMntrMetric(options, "zk_server_state", value, hostLabels, metrics)
+
+		// zxid is hex, its high 32 bits are epoch, which grows with every leader election
+		case "Zxid":
+			zxid, perr := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 64)
+			if perr != nil {
+				err = fmt.Errorf("malformed zxid %q", value)
+				continue
+			}
+			metrics.add("zk_last_zxid", hostLabels, strconv.FormatUint(zxid, 10))
+			metrics.add("zk_current_epoch", hostLabels, strconv.FormatUint(zxid>>32, 10))
+
+		case "Latency min/avg/max":
+			latencies := strings.Split(value, "/")
+			if len(latencies) != 3 {
+				err = fmt.Errorf("malformed latency %q", value)
+				continue
+			}
+			addNumber("zk_min_latency", latencies[0])
+			addNumber("zk_avg_latency", latencies[1])
+			addNumber("zk_max_latency", latencies[2])
+
+		default:
+			if name, ok := srvrMetrics[key]; ok {
+				addNumber(name, value)
+			}
+		}
+	}
+	return err
+}
+
+// parse 'cons' response, which lists connections with their stats like:
+//
+//	/10.0.0.5:51234[1](queued=0,recved=120,sent=121,sid=0x100000a2b0c0001,lop=PING,est=1625750000000,to=30000,lcxid=0x5,lzxid=0x100000002,lresp=1625750100000,llat=0,minlat=0,avglat=1,maxlat=12)
+//	/10.0.0.6:40012[0](queued=0,recved=1,sent=0)
+//
+// per-connection labels would explode cardinality, so stats are summed up per
+// server, and the highest 'maxlat' is reported; exporter's own connection is included
+func parseCons(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	var connections, queued, received, sent, maxLatency uint64
+	var err error
+	for _, l := range strings.Split(res, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		m := consConnectionRE.FindStringSubmatch(l)
+		if m == nil {
+			err = fmt.Errorf("malformed 'cons' line %q", l)
+			continue
+		}
+		connections++
+
+		for _, field := range strings.Split(m[1], ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "queued", "recved", "sent", "maxlat":
+			default:
+				continue
+			}
+			value, perr := strconv.ParseUint(kv[1], 10, 64)
+			if perr != nil {
+				err = fmt.Errorf("malformed 'cons' value %q", field)
+				continue
+			}
+			switch kv[0] {
+			case "queued":
+				queued += value
+			case "recved":
+				received += value
+			case "sent":
+				sent += value
+			case "maxlat":
+				if value > maxLatency {
+					maxLatency = value
+				}
+			}
+		}
+	}
+
+	metrics.add("zk_cons_connections", hostLabels, strconv.FormatUint(connections, 10))
+	metrics.add("zk_cons_queued", hostLabels, strconv.FormatUint(queued, 10))
+	metrics.add("zk_cons_packets_received", hostLabels, strconv.FormatUint(received, 10))
+	metrics.add("zk_cons_packets_sent", hostLabels, strconv.FormatUint(sent, 10))
+	metrics.add("zk_cons_max_latency", hostLabels, strconv.FormatUint(maxLatency, 10))
+	return err
+}
+
+// parse 'dirs' response, available since zk v3.6, which reports sizes of snapshot and log directories:
+//
+//	datadir_size: 3146
+//	logdir_size: 67108880
+func parseDirs(options *Options, res string, hostLabels []label, metrics *metricSet) error {
+	found := false
+	for _, l := range strings.Split(res, "\n") {
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, ok := dirsMetrics[strings.TrimSpace(kv[0])]
+		if !ok {
+			continue
+		}
+		value, ok := parseNumber(strings.TrimSpace(kv[1]))
+		if !ok {
+			return fmt.Errorf("malformed 'dirs' value %q", kv[1])
+		}
+		metrics.add(name, hostLabels, value)
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("unexpected 'dirs' response %q", res)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package exporter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// labels of 'mntr' keys are merged with host labels, only metric name is sanitized
+func TestParseMntrKeyLabels(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	res := "zk_write_per_namespace{key=\"solrcloud7\",quantile=\"0.5\"}\t3\n" +
+		"zk_write_per_namespace{key=\"solrcloud7\",quantile=\"0.99\"}\t7\n" +
+		"zk_read_per_namespace{key=\"my-ns.v2\"}\t4\n"
+
+	metrics := newMetricSet()
+	parseMntr(newTestOptions(), res, hostLabels, metrics)
+
+	want := []struct{ id, value string }{
+		{`zk_read_per_namespace{key="my-ns.v2",zk_host="10.0.0.1:2181"}`, "4"},
+		{`zk_write_per_namespace{key="solrcloud7",quantile="0.5",zk_host="10.0.0.1:2181"}`, "3"},
+		{`zk_write_per_namespace{key="solrcloud7",quantile="0.99",zk_host="10.0.0.1:2181"}`, "7"},
+	}
+	got := metrics.sorted()
+	if len(got) != len(want) {
+		t.Fatalf("%d series, want %d", len(got), len(want))
+	}
+	for i, s := range got {
+		if s.id() != want[i].id || s.value != want[i].value {
+			t.Errorf("series %d = %s %s, want %s %s", i, s.id(), s.value, want[i].id, want[i].value)
+		}
+	}
+}
+
+func TestParseDirs(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		res             string
+		datadir, logdir string
+		err             bool
+	}{
+		{res: "datadir_size: 1024\nlogdir_size: 2048\n", datadir: "1024", logdir: "2048"},
+		{res: "datadir_size: 0\nlogdir_size: 67108864", datadir: "0", logdir: "67108864"},
+		// zk 3.6 without separate transaction log directory
+		{res: "datadir_size: 1024\n", datadir: "1024"},
+		{res: "datadir_size: abc\nlogdir_size: 2048\n", err: true},
+		{res: "", err: true},
+		{res: "dirs is not executed because it is not in the whitelist.\n", err: true},
+	}
+	for _, tt := range tests {
+		metrics := newMetricSet()
+		err := parseDirs(newTestOptions(), tt.res, hostLabels, metrics)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.res, err)
+			continue
+		}
+		for name, want := range map[string]string{"zk_datadir_size_bytes": tt.datadir, "zk_logdir_size_bytes": tt.logdir} {
+			v, ok := seriesValue(metrics, name, hostLabels)
+			if want == "" && ok {
+				t.Errorf("%q: %s is exported", tt.res, name)
+			}
+			if want != "" && v != want {
+				t.Errorf("%q: %s = %q, want %q", tt.res, name, v, want)
+			}
+		}
+	}
+}
+
+// with '-commands mntr,srvr' values of 'mntr' win over those of 'srvr'
+func TestParseSrvrAfterMntr(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	options := newTestOptions()
+	metrics := newMetricSet()
+	mntr := "zk_avg_latency\t0.25\nzk_packets_received\t1000\nzk_packets_sent\t999\nzk_znode_count\t5\n"
+	srvr := "Latency min/avg/max: 0/1/10\nReceived: 100\nSent: 99\nConnections: 3\nMode: follower\nNode count: 42\n"
+	if err := parseMntr(options, mntr, hostLabels, metrics); err != nil {
+		t.Fatalf("parseMntr: %v", err)
+	}
+	if err := parseSrvr(options, srvr, hostLabels, metrics); err != nil {
+		t.Fatalf("parseSrvr: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"zk_avg_latency":      "0.25",
+		"zk_packets_received": "1000",
+		"zk_packets_sent":     "999",
+		"zk_znode_count":      "5",
+		// not reported by 'mntr', taken from 'srvr'
+		"zk_max_latency":           "10",
+		"zk_num_alive_connections": "3",
+	} {
+		if v, _ := seriesValue(metrics, name, hostLabels); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+// newTestOptions returns options with logger and exporter-wide state of their own,
+// as set up by New; log is discarded
+func newTestOptions() *Options {
+	options := &Options{log: &leveledLogger{out: ioutil.Discard}}
+	options.initState()
+	return options
+}
+
+// seriesValue returns value of series with given name and labels
+func seriesValue(metrics *metricSet, name string, labels []label) (string, bool) {
+	id := series{name: name, labels: labels}.id()
+	for _, s := range metrics.sorted() {
+		if s.id() == id {
+			return s.value, true
+		}
+	}
+	return "", false
+}
+
+func TestParseWchs(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	res := "2 connections watching 5 paths\nTotal watches:7\n"
+
+	metrics := newMetricSet()
+	if err := parseWchs(newTestOptions(), res, hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{"zk_watch_connections": "2", "zk_watch_paths": "5", "zk_watch_count": "7"} {
+		if v, _ := seriesValue(metrics, name, hostLabels); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+// some builds and proxies terminate lines with CRLF
+func TestParseCRLF(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		cmd, res    string
+		name, value string
+	}{
+		{"mntr", "zk_znode_count\t42\r\nzk_server_state\tleader\r\n", "zk_znode_count", "42"},
+		{"mntr", "zk_avg_latency  \t0.5 \r\n", "zk_avg_latency", "0.5"},
+		{"srvr", "Zookeeper version: 3.6.3--6401e4ad, built on 04/08/2021 16:35 GMT\r\nNode count: 42\r\nMode: follower\r\n", "zk_znode_count", "42"},
+		{"dirs", "datadir_size: 1024\r\nlogdir_size: 2048\r\n", "zk_logdir_size_bytes", "2048"},
+		{"wchs", "2 connections watching 5 paths\r\nTotal watches:7\r\n", "zk_watch_count", "7"},
+		{"conf", "clientPort=2181\r\ntickTime=2000\r\n", "zk_conf_tick_time", "2000"},
+	}
+	for _, tt := range tests {
+		metrics := newMetricSet()
+		// the same as scrapeHost does before parsing
+		res := strings.Replace(tt.res, "\r\n", "\n", -1)
+		if err := commandParsers[tt.cmd](newTestOptions(), res, hostLabels, metrics); err != nil {
+			t.Errorf("%s %q: unexpected error: %v", tt.cmd, tt.res, err)
+			continue
+		}
+		if v, _ := seriesValue(metrics, tt.name, hostLabels); v != tt.value {
+			t.Errorf("%s %q: %s = %q, want %q", tt.cmd, tt.res, tt.name, v, tt.value)
+		}
+		for _, s := range metrics.sorted() {
+			if strings.ContainsAny(s.id(), "\r") {
+				t.Errorf("%s %q: series %s contains carriage return", tt.cmd, tt.res, s.id())
+			}
+		}
+	}
+
+	// 'mntr' parser doesn't rely on normalization
+	metrics := newMetricSet()
+	if err := parseMntr(newTestOptions(), "zk_znode_count\t42\r\nzk_server_state\tleader\r\n", hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := seriesValue(metrics, "zk_znode_count", hostLabels); v != "42" {
+		t.Errorf("zk_znode_count = %q, want 42", v)
+	}
+	if v, _ := seriesValue(metrics, "zk_server_role", []label{{"role", "leader"}, {"zk_host", "10.0.0.1:2181"}}); v != "1" {
+		t.Errorf("zk_server_role{role=\"leader\"} = %q, want 1", v)
+	}
+}
+
+func TestParseCons(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	res := " /127.0.0.1:50000[1](queued=0,recved=5,sent=5,sid=0x1,lop=PING,est=1,to=30000,lcxid=0x0,lzxid=0x1,lresp=1,llat=0,minlat=0,avglat=0,maxlat=12)\n" +
+		" /[0:0:0:0:0:0:0:1]:50001[1](queued=2,recved=7,sent=6,maxlat=3)\n" +
+		" /10.0.0.9:50002[0](queued=0,recved=1,sent=0)\n" +
+		"\n"
+
+	metrics := newMetricSet()
+	if err := parseCons(newTestOptions(), res, hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name, value string
+	}{
+		{"zk_cons_connections", "3"},
+		{"zk_cons_queued", "2"},
+		{"zk_cons_packets_received", "13"},
+		{"zk_cons_packets_sent", "11"},
+		{"zk_cons_max_latency", "12"},
+	}
+	for _, tt := range tests {
+		if v, _ := seriesValue(metrics, tt.name, hostLabels); v != tt.value {
+			t.Errorf("%s = %q, want %q", tt.name, v, tt.value)
+		}
+	}
+
+	// malformed lines are reported, but don't discard the rest
+	metrics = newMetricSet()
+	err := parseCons(newTestOptions(), " /127.0.0.1:50000[1](queued=1,recved=5,sent=5)\ngarbage\n /127.0.0.1:50001[1](recved=x)\n", hostLabels, metrics)
+	if err == nil {
+		t.Error("expected error")
+	}
+	if v, _ := seriesValue(metrics, "zk_cons_connections", hostLabels); v != "2" {
+		t.Errorf("zk_cons_connections = %q, want 2", v)
+	}
+	if v, _ := seriesValue(metrics, "zk_cons_packets_received", hostLabels); v != "5" {
+		t.Errorf("zk_cons_packets_received = %q, want 5", v)
+	}
+
+	// server without clients
+	metrics = newMetricSet()
+	if err := parseCons(newTestOptions(), "\n", hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := seriesValue(metrics, "zk_cons_connections", hostLabels); v != "0" {
+		t.Errorf("zk_cons_connections = %q, want 0", v)
+	}
+}
+
+func TestParseMntrDelimiters(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		line        string
+		name, value string
+		labels      []label
+	}{
+		{line: "zk_znode_count\t42", name: "zk_znode_count", value: "42"},
+		{line: "zk_znode_count    42", name: "zk_znode_count", value: "42"},
+		{line: "zk_znode_count \t \t42  ", name: "zk_znode_count", value: "42"},
+		{line: "  zk_znode_count 42", name: "zk_znode_count", value: "42"},
+		// value with spaces is kept whole
+		{
+			line:   "zk_version\t3.6.3--6401e4ad, built on 04/08/2021 16:35 GMT",
+			name:   "zk_version",
+			value:  "1",
+			labels: []label{{"version", "3.6.3"}},
+		},
+		{
+			line:   "zk_peer_state  following - broadcast",
+			name:   "zk_peer_state",
+			value:  "1",
+			labels: []label{{"state", "following - broadcast"}},
+		},
+		// label values of key may contain spaces
+		{
+			line:   "zk_write_per_namespace{key=\"my ns\"}\t3",
+			name:   "zk_write_per_namespace",
+			value:  "3",
+			labels: []label{{"key", "my ns"}},
+		},
+	}
+	for _, tt := range tests {
+		metrics := newMetricSet()
+		if err := parseMntr(newTestOptions(), tt.line+"\n", hostLabels, metrics); err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.line, err)
+			continue
+		}
+		labels := append(tt.labels[:len(tt.labels):len(tt.labels)], hostLabels...)
+		if v, ok := seriesValue(metrics, tt.name, labels); !ok || v != tt.value {
+			t.Errorf("%q: %s%v = %q (exported: %v), want %q", tt.line, tt.name, labels, v, ok, tt.value)
+		}
+	}
+}
+
+func TestParseConf(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	res := "clientPort=2181\ndataDir=/data\ntickTime=2000\n" +
+		"server.1=zk-0:2888:3888:participant;0.0.0.0:2181\n" +
+		"server.2=zk-1:2888:3888:participant;0.0.0.0:2181\n" +
+		"server.3=zk-2:2888:3888:observer;0.0.0.0:2181\n" +
+		"version=10000000a\n"
+
+	metrics := newMetricSet()
+	if err := parseConf(newTestOptions(), res, hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name, value string
+	}{
+		{"zk_conf_client_port", "2181"},
+		{"zk_conf_tick_time", "2000"},
+		{"zk_conf_quorum_size", "2"},
+		{"zk_conf_version", "4294967306"},
+	}
+	for _, tt := range tests {
+		if v, ok := metrics.value(tt.name, hostLabels); !ok || v != tt.value {
+			t.Errorf("%s = %q (exported: %v), want %q", tt.name, v, ok, tt.value)
+		}
+	}
+	if _, ok := metrics.value("zk_conf_data_dir", hostLabels); ok {
+		t.Errorf("non-numeric zk_conf_data_dir is exported")
+	}
+}
+
+func TestParseMntrRuntimeMetrics(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	res := "zk_version\t3.6.3--6401e4ad, built on 04/08/2021 16:35 GMT\n" +
+		"zk_znode_count\t42\n" +
+		"zk_jvm_heap_used\t104857600\n" +
+		"jvm_heap_max\t536870912\n" +
+		"jvm_threads_count\t57\n" +
+		"jvm_gc_collection_count\t12\n" +
+		"jvm_gc_collection_time_ms\t1500\n" +
+		"process_open_fds\t128\n" +
+		"jvm_buffer_pool_used\t4096\n"
+
+	metrics := newMetricSet()
+	if err := parseMntr(newTestOptions(), res, hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name, value, typ string
+	}{
+		{"zk_jvm_heap_used_bytes", "104857600", "gauge"},
+		{"zk_jvm_heap_max_bytes", "536870912", "gauge"},
+		{"zk_jvm_threads", "57", "gauge"},
+		{"zk_jvm_gc_collections_total", "12", "counter"},
+		{"zk_jvm_gc_collection_seconds_total", "1.5", "counter"},
+		{"zk_process_open_fds", "128", "gauge"},
+		// unknown runtime metrics get prefix and are gauges
+		{"zk_jvm_buffer_pool_used", "4096", "gauge"},
+	}
+	for _, tt := range tests {
+		if v, ok := metrics.value(tt.name, hostLabels); !ok || v != tt.value {
+			t.Errorf("%s = %q (exported: %v), want %q", tt.name, v, ok, tt.value)
+		}
+		if typ := metrics.lookupInfo(tt.name).typ; typ != tt.typ {
+			t.Errorf("%s type = %q, want %q", tt.name, typ, tt.typ)
+		}
+	}
+	for _, name := range []string{"zk_jvm_heap_used", "jvm_heap_max", "zk_jvm_gc_collection_time_ms", "zk_jvm_gc_collection_time_seconds"} {
+		if _, ok := metrics.value(name, hostLabels); ok {
+			t.Errorf("%s is exported under reported name", name)
+		}
+	}
+
+	// absent metrics aren't synthesized
+	metrics = newMetricSet()
+	if err := parseMntr(newTestOptions(), "zk_znode_count\t42\n", hostLabels, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range metrics.sorted() {
+		if strings.HasPrefix(s.name, "zk_jvm_") || strings.HasPrefix(s.name, "zk_process_") {
+			t.Errorf("%s is exported by server which doesn't report it", s.name)
+		}
+	}
+}
+
+// malformed line, e.g. key without value of response truncated on shutdown,
+// is logged and skipped, lines around it are parsed
+func TestParseMntrKeyWithoutValue(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	for _, res := range []string{
+		"zk_znode_count\t42\nzk_some_key\nzk_watch_count\t7\n",
+		"zk_some_key\nzk_znode_count\t42\nzk_watch_count\t7\n",
+		"zk_znode_count\t42\nzk_watch_count\t7\nzk_some_key \t ",
+	} {
+		var log bytes.Buffer
+		options := newTestOptions()
+		options.log = &leveledLogger{out: &log, level: levelWarn}
+		metrics := newMetricSet()
+		if err := parseMntr(options, res, hostLabels, metrics); err != nil {
+			t.Errorf("%q: unexpected error: %v", res, err)
+		}
+		if v, _ := metrics.value("zk_znode_count", hostLabels); v != "42" {
+			t.Errorf("%q: zk_znode_count = %q, want 42", res, v)
+		}
+		if v, _ := metrics.value("zk_watch_count", hostLabels); v != "7" {
+			t.Errorf("%q: zk_watch_count = %q, want 7", res, v)
+		}
+		if _, ok := metrics.value("zk_some_key", hostLabels); ok {
+			t.Errorf("%q: zk_some_key without value is exported", res)
+		}
+		if !strings.Contains(log.String(), "line=zk_some_key") {
+			t.Errorf("%q: skipped line isn't logged, log: %q", res, log.String())
+		}
+	}
+}
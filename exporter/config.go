@@ -0,0 +1,211 @@
+package exporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// clusterConfig describes zk ensemble listed in -config file, its metrics
+// get 'cluster' label with the ensemble name
+type clusterConfig struct {
+	Name    string
+	Hosts   []string
+	Timeout time.Duration
+	TLS     *clusterTLSConfig
+
+	// port of zk metrics provider, overrides -zk-metrics-port if set,
+	// 0 selects 4lw commands
+	MetricsPort *int
+
+	// 4lw commands of cluster, -commands are used if empty
+	Commands []string
+
+	// settings of hosts which override settings of cluster, keyed by host as listed
+	HostConfigs map[string]hostConfig
+}
+
+// settings of a single host of cluster, e.g. commands of a host of mixed-version
+// ensemble with different whitelist, or tls of a host which requires mTLS
+type hostConfig struct {
+	Commands []string
+	TLS      *clusterTLSConfig
+}
+
+// tls settings of cluster or host, the same as -zk-tls-* flags; tls can
+// be disabled with 'enabled: false', e.g. if -zk-tls-auth is set
+type clusterTLSConfig struct {
+	Enabled    bool
+	Cert       string
+	Key        string
+	CA         string
+	ServerName string
+	Insecure   bool
+}
+
+// read -config file, which lists clusters like:
+//
+//	clusters:
+//	  - name: prod
+//	    hosts: [10.0.0.1:2181, 10.0.0.2:2181, 10.0.0.3:2181]
+//	    timeout: 10s
+//	    tls:
+//	      cert: /etc/zk/client.crt
+//	      key: /etc/zk/client.key
+//	      ca: /etc/zk/ca.crt
+//	  - name: staging
+//	    hosts:
+//	      - 10.1.0.1:2181
+//	      - host: 10.1.0.2:2181
+//	        commands: [srvr, ruok]
+//	      - host: 10.1.0.3:2281
+//	        tls:
+//	          cert: /etc/zk/staging.crt
+//	          key: /etc/zk/staging.key
+//	    metrics_port: 7000
+func readConfigFile(path string) ([]clusterConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+// configFile is the layout of -config file, unknown fields are rejected
+type configFile struct {
+	Clusters []clusterFileConfig `yaml:"clusters"`
+}
+
+type clusterFileConfig struct {
+	Name        string         `yaml:"name"`
+	Hosts       []hostEntry    `yaml:"hosts"`
+	Commands    []string       `yaml:"commands"`
+	Timeout     string         `yaml:"timeout"`
+	TLS         *tlsFileConfig `yaml:"tls"`
+	MetricsPort *int           `yaml:"metrics_port"`
+}
+
+// hostEntry is either 'host:port' string, or map with 'host', and 'commands'
+// and 'tls' which are used for that host instead of cluster's ones
+type hostEntry struct {
+	Host     string         `yaml:"host"`
+	Commands []string       `yaml:"commands"`
+	TLS      *tlsFileConfig `yaml:"tls"`
+}
+
+func (h *hostEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&h.Host); err == nil {
+		return nil
+	}
+	type plain hostEntry
+	return unmarshal((*plain)(h))
+}
+
+type tlsFileConfig struct {
+	Enabled    *bool  `yaml:"enabled"`
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	CA         string `yaml:"ca"`
+	ServerName string `yaml:"server_name"`
+	Insecure   bool   `yaml:"insecure"`
+}
+
+func parseConfig(data []byte) ([]clusterConfig, error) {
+	var file configFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Clusters) == 0 {
+		return nil, fmt.Errorf("expected non-empty 'clusters' list at top level")
+	}
+
+	var clusters []clusterConfig
+	names := map[string]bool{}
+	for i, fc := range file.Clusters {
+		c, err := fc.clusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cluster #%d: %v", i+1, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate cluster name %q", c.Name)
+		}
+		names[c.Name] = true
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+func (fc *clusterFileConfig) clusterConfig() (clusterConfig, error) {
+	c := clusterConfig{Name: fc.Name, MetricsPort: fc.MetricsPort}
+	if c.Name == "" {
+		return c, fmt.Errorf("'name' is required")
+	}
+
+	var err error
+	if fc.Commands != nil {
+		if c.Commands, err = parseCommands(strings.Join(fc.Commands, ",")); err != nil {
+			return c, fmt.Errorf("'commands': %v", err)
+		}
+	}
+	// duration like '10s', plain number is treated as seconds, the same as -timeout flag
+	if fc.Timeout != "" {
+		if c.Timeout, err = parseSecondsDuration(fc.Timeout); err != nil {
+			return c, fmt.Errorf("'timeout': %v", err)
+		}
+		if c.Timeout <= 0 {
+			return c, fmt.Errorf("'timeout' must be positive")
+		}
+	}
+	if c.TLS, err = fc.TLS.clusterTLSConfig(); err != nil {
+		return c, fmt.Errorf("'tls': %v", err)
+	}
+
+	for i, h := range fc.Hosts {
+		if h.Host == "" {
+			return c, fmt.Errorf("host #%d: 'host' is required", i+1)
+		}
+		c.Hosts = append(c.Hosts, h.Host)
+		if h.Commands == nil && h.TLS == nil {
+			continue
+		}
+		var hc hostConfig
+		if h.Commands != nil {
+			if hc.Commands, err = parseCommands(strings.Join(h.Commands, ",")); err != nil {
+				return c, fmt.Errorf("host #%d: 'commands': %v", i+1, err)
+			}
+		}
+		if hc.TLS, err = h.TLS.clusterTLSConfig(); err != nil {
+			return c, fmt.Errorf("host #%d: 'tls': %v", i+1, err)
+		}
+		if c.HostConfigs == nil {
+			c.HostConfigs = map[string]hostConfig{}
+		}
+		c.HostConfigs[h.Host] = hc
+	}
+	if len(c.Hosts) == 0 {
+		return c, fmt.Errorf("cluster %q has no hosts", c.Name)
+	}
+	return c, nil
+}
+
+// tls is enabled unless 'enabled: false' is set
+func (ft *tlsFileConfig) clusterTLSConfig() (*clusterTLSConfig, error) {
+	if ft == nil {
+		return nil, nil
+	}
+	t := &clusterTLSConfig{
+		Enabled:    ft.Enabled == nil || *ft.Enabled,
+		Cert:       ft.Cert,
+		Key:        ft.Key,
+		CA:         ft.CA,
+		ServerName: ft.ServerName,
+		Insecure:   ft.Insecure,
+	}
+	if t.Enabled && (t.Cert == "" || t.Key == "") {
+		return nil, fmt.Errorf("'cert' and 'key' are required")
+	}
+	return t, nil
+}
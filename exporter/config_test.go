@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	doc := `
+clusters:
+  - name: prod # comment
+    hosts: ["10.0.0.1:2181", 10.0.0.2:2181]
+    timeout: 10
+    tls: {cert: c.crt, key: c.key}
+  - name: staging
+    commands: [srvr, ruok]
+    hosts:
+    - 10.1.0.1:2181
+    - host: 10.1.0.2:2281
+      tls:
+        enabled: false
+    metrics_port: 7000
+`
+	clusters, err := parseConfig([]byte(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port := 7000
+	want := []clusterConfig{
+		{
+			Name:    "prod",
+			Hosts:   []string{"10.0.0.1:2181", "10.0.0.2:2181"},
+			Timeout: 10 * time.Second,
+			TLS:     &clusterTLSConfig{Enabled: true, Cert: "c.crt", Key: "c.key"},
+		},
+		{
+			Name:        "staging",
+			Hosts:       []string{"10.1.0.1:2181", "10.1.0.2:2281"},
+			Commands:    []string{"srvr", "ruok"},
+			MetricsPort: &port,
+			HostConfigs: map[string]hostConfig{"10.1.0.2:2281": {TLS: &clusterTLSConfig{}}},
+		},
+	}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("parseConfig() = %+v, want %+v", clusters, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{"", "non-empty 'clusters'"},
+		{"clusters: []", "non-empty 'clusters'"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n    tiemout: 5s", "tiemout"},
+		{"clusters:\n  - name: prod\n    hosts:\n", "has no hosts"},
+		{"clusters:\n  - hosts: [a:2181]", "'name' is required"},
+		{"clusters:\n  - name: prod\n    hosts: [{commands: [srvr]}]", "'host' is required"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n    commands: [mntr, nope]", "'commands'"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n    tls: {ca: ca.crt}", "'cert' and 'key' are required"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n    timeout: soon", "'timeout'"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n    timeout: 0", "'timeout' must be positive"},
+		{"clusters:\n  - name: prod\n    hosts: [a:2181]\n  - name: prod\n    hosts: [b:2181]", "duplicate cluster name"},
+	}
+	for _, tt := range tests {
+		_, err := parseConfig([]byte(tt.doc))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseConfig(%q) error = %v, want error containing %q", tt.doc, err, tt.want)
+		}
+	}
+}
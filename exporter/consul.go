@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulServiceEntry is an entry of consul '/v1/health/service/:service' response,
+// only fields needed to build 'host:port' are decoded
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// query consul for healthy instances of zk service and return them as 'host:port' list;
+// service address is used if set, node address otherwise
+func discoverConsulHosts(address, service string, timeout time.Duration) ([]string, error) {
+	u := fmt.Sprintf("%s/v1/health/service/%s?passing=true", strings.TrimRight(address, "/"), url.PathEscape(service))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// same env variable as used by consul cli
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("cannot decode response: %s", err)
+	}
+
+	hosts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		hosts = append(hosts, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no healthy instances of service %s", service)
+	}
+
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// periodically query consul and update hosts in options once the set of
+// healthy instances is changed; previous hosts are kept if consul is unreachable
+func watchConsulService(options *Options, address, service string, interval time.Duration) {
+	last := strings.Join(options.GetHosts(), ",")
+
+	for range time.Tick(interval) {
+		hosts, err := discoverConsulHosts(address, service, options.connectTimeout())
+		if err != nil {
+			options.log.Warn("cannot discover zk hosts in consul, keeping previous hosts", "service", service, "error", err)
+			continue
+		}
+
+		hosts = normalizeHosts(hosts, options.log)
+		if joined := strings.Join(hosts, ","); joined != last {
+			last = joined
+			options.SetHosts(hosts)
+			options.log.Info("zookeeper hosts discovered", "service", service, "hosts", joined)
+		}
+	}
+}
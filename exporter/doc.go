@@ -0,0 +1,18 @@
+// Package exporter scrapes zookeeper servers with 4lw commands, AdminServer or
+// PrometheusMetricsProvider and exports their metrics to prometheus.
+//
+// Main runs the standalone zookeeper-exporter. Programs which have their own
+// registry register Exporter, which scrapes zk servers on each collection:
+//
+//	e, err := exporter.New(&exporter.Options{
+//		Hosts:   []string{"10.0.0.1:2181", "10.0.0.2:2181", "tls://10.0.0.3:2281"},
+//		Timeout: 5 * time.Second,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	registry.MustRegister(e)
+//
+// Hosts may be replaced at runtime with Options.SetHosts, e.g. when they're
+// discovered by embedding program.
+package exporter
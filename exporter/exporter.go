@@ -0,0 +1,1374 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	commandNotAllowedMessage  = "command isn't allowed, see '4lw.commands.whitelist' ZK config parameter"
+	instanceNotServingMessage = "This ZooKeeper instance is not currently serving requests"
+	cmdNotExecutedSffx        = "is not executed because it is not in the whitelist."
+	retryBackoff              = 100 * time.Millisecond
+)
+
+// errResponseTooLarge is returned when response exceeds -max-response-bytes
+var errResponseTooLarge = errors.New("response exceeds limit")
+
+var (
+	// version with two to four components and optional pre-release qualifier, e.g. '3.8',
+	// '3.9.0-SNAPSHOT' or '3.5.5.1'; may follow vendor prefix, e.g. 'zookeeper-3.4.6-mapr-1604',
+	// build metadata after it is dropped, e.g. '3.6.3--6401e4ad, built on 04/08/2021 16:35 GMT'
+	versionRE = regexp.MustCompile(`(?:^|[^0-9.])v?([0-9]+\.[0-9]+(?:\.[0-9]+){0,2}(?i:-(?:snapshot|alpha[0-9]*|beta[0-9]*|rc[0-9]*))?)(?:[^0-9.]|$)`)
+
+	// durations which 'mntr' reports in milliseconds: uptime, latencies, and average, min,
+	// max and sum of summaries with '_ms' suffix, e.g. 'zk_max_local_write_committed_time_ms';
+	// count of summary, e.g. 'zk_cnt_local_write_committed_time_ms', is a number of events
+	millisecondsRE = regexp.MustCompile(`^zk_(?:uptime|(?:avg|min|max)_latency|(?:avg|min|max|sum)_[a-zA-Z0-9_]+_ms)$`)
+
+	// buckets of zk_exporter_command_duration_seconds, from sub-millisecond answers
+	// of idle servers up to default -timeout
+	commandDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// exporterState holds exporter metrics which persist across scrapes and circuit
+// breaker of zk servers; every Exporter has its own, shared by its clusters
+type exporterState struct {
+	// counts failed commands per host and command across scrapes
+	scrapeErrors *prometheus.CounterVec
+	// counts recovered panics, per-host scrapes keep running even if one of them panics
+	scrapePanics prometheus.Counter
+	// count warnings which are otherwise seen only in logs, without zk_host label
+	// to keep them cheap: zk hostnames which can't be resolved, commands which
+	// aren't whitelisted, per command, and mntr values which aren't numbers
+	resolveFailures prometheus.Counter
+	notWhitelisted  *prometheus.CounterVec
+	nonDigitSkipped prometheus.Counter
+	// duration of 4lw commands per host and command
+	commandDuration *prometheus.HistogramVec
+	// consecutive failures of zk servers across scrapes, set up by -breaker-failures
+	breaker *circuitBreaker
+
+	// labels which identify zk server in per-host metrics: zk_host and, if
+	// clusters are set, cluster
+	hostLabelNames []string
+	// filter of exporter, applies to its own metrics as well
+	filter *MetricFilter
+}
+
+// per-host metrics have cluster label only if exporter is clustered; counters
+// without labels are exported as zero before the first increment
+func newExporterState(clustered bool, filter *MetricFilter) *exporterState {
+	hostLabelNames := []string{"zk_host"}
+	if clustered {
+		hostLabelNames = []string{"cluster", "zk_host"}
+	}
+	return &exporterState{
+		scrapeErrors:    prometheus.NewCounterVec(counterOpts("zk_exporter_scrape_errors_total"), append(hostLabelNames, "command")),
+		scrapePanics:    prometheus.NewCounter(counterOpts("zk_exporter_scrape_panics_total")),
+		resolveFailures: prometheus.NewCounter(counterOpts("zk_exporter_resolve_failures_total")),
+		notWhitelisted:  prometheus.NewCounterVec(counterOpts("zk_exporter_not_whitelisted_total"), []string{"command"}),
+		nonDigitSkipped: prometheus.NewCounter(counterOpts("zk_exporter_nondigit_skipped_total")),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "zk_exporter_command_duration_seconds",
+			Help:    knownMetrics["zk_exporter_command_duration_seconds"].help,
+			Buckets: commandDurationBuckets,
+		}, append(hostLabelNames, "command")),
+		breaker:        newCircuitBreaker(),
+		hostLabelNames: hostLabelNames,
+		filter:         filter,
+	}
+}
+
+// options of exporter counter, with help of knownMetrics
+func counterOpts(name string) prometheus.CounterOpts {
+	return prometheus.CounterOpts{Name: name, Help: knownMetrics[name].help}
+}
+
+// labels of per-host exporter metric of zk server, followed by extra labels,
+// e.g. command; cluster label is empty for hosts outside of clusters
+func (s *exporterState) labels(hostLabels []label, extra ...label) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, name := range s.hostLabelNames {
+		labels[name] = labelValue(hostLabels, name)
+	}
+	for _, l := range extra {
+		labels[l.name] = l.value
+	}
+	return labels
+}
+
+// forgetHost drops exporter metrics and circuit breaker of zk server with given
+// host key and labels; labels are nil if only circuit breaker knows the server
+func (s *exporterState) forgetHost(key string, hostLabels []label) {
+	if hostLabels != nil {
+		s.scrapeErrors.DeletePartialMatch(s.labels(hostLabels))
+		s.commandDuration.DeletePartialMatch(s.labels(hostLabels))
+	}
+	s.breaker.forget(key)
+}
+
+// Describe sends nothing, exporter metrics are collected along with metrics of
+// zk servers, which are unchecked
+func (s *exporterState) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends exporter metrics which persist across scrapes, except those
+// denied by filter
+func (s *exporterState) Collect(ch chan<- prometheus.Metric) {
+	collectors := map[string]prometheus.Collector{
+		"zk_exporter_scrape_errors_total":      s.scrapeErrors,
+		"zk_exporter_scrape_panics_total":      s.scrapePanics,
+		"zk_exporter_resolve_failures_total":   s.resolveFailures,
+		"zk_exporter_not_whitelisted_total":    s.notWhitelisted,
+		"zk_exporter_nondigit_skipped_total":   s.nonDigitSkipped,
+		"zk_exporter_command_duration_seconds": s.commandDuration,
+	}
+	for name, c := range collectors {
+		if s.filter.allowed(name) {
+			c.Collect(ch)
+		}
+	}
+}
+
+// Options configure scraping of zk servers and serving of metrics, fields
+// correspond to command line flags of the standalone exporter
+type Options struct {
+	Timeout         time.Duration
+	ConnectTimeout  time.Duration
+	ReadTimeout     time.Duration
+	Hosts           []string
+	Locations       []string
+	HealthLocation  string
+	ReadyLocation   string
+	ProbeLocation   string
+	Listen          string
+	TLSConfig       *tls.Config
+	HostTLSConfigs  map[string]*tls.Config
+	SchemeTLS       *tls.Config
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	AdminPort       int
+	AdminTLS        *tls.Config
+	AdminHosts      map[string]*tls.Config
+	MetricsPort     int
+	HostLabelMode   string
+	HostAliases     map[string]string
+	AdminUser       string
+	AdminPassword   string
+	Retries         int
+	SkipRuok        bool
+	AssumeRuok      bool
+	MaxConcurrency  int
+	BreakerFailures int
+	BreakerProbe    int
+	ScrapeJitter    time.Duration
+	KeepAlive       time.Duration
+	MaxResponseSize int64
+	ProxyURL        *url.URL
+	ResolveAll      bool
+	Commands        []string
+	HostCommands    map[string][]string
+	ShutdownTimeout time.Duration
+	ScrapeInterval  time.Duration
+	Pprof           bool
+	DebugResponses  bool
+	AccessLog       bool
+	Strict          bool
+	MetricPrefix    string
+	MetricFilter    *MetricFilter
+	Timestamps      bool
+
+	// FullVersionLabel adds original version string as 'full_version' label of zk_version;
+	// MntrDotLabel is label which gets suffix of dotted mntr keys
+	FullVersionLabel bool
+	MntrDotLabel     string
+
+	// log is written to LogOutput, stdout if nil; LogLevel and LogFormat are the
+	// same as -log-level and -log-format flags, 'info' and 'text' if empty
+	LogOutput io.Writer
+	LogLevel  string
+	LogFormat string
+
+	// zk clusters, e.g. listed in -config file, Hosts are ignored if set;
+	// Cluster is name of the cluster, used as 'cluster' label
+	Clusters []*Options
+	Cluster  string
+
+	// metrics are served over https if cert and key are set,
+	// ListenTLSConfig holds client certificate verification settings
+	ListenTLSCert   string
+	ListenTLSKey    string
+	ListenTLSConfig *tls.Config
+
+	// if AuthUsername is set, metrics location requires http basic auth,
+	// health and readiness probes stay open
+	AuthUsername string
+	AuthPassword string
+
+	// guards Hosts, HostAliases, HostTLSConfigs and AdminHosts, which may be updated at runtime
+	hostsMu sync.RWMutex
+
+	// set for probe targets, which aren't tracked by exporter-wide metrics
+	probe bool
+
+	// logger and exporter-wide state, set up by initState and shared by clusters
+	log   *leveledLogger
+	state *exporterState
+}
+
+// set up logger and exporter-wide state, unless they're already set, and share
+// them with clusters
+func (o *Options) initState() error {
+	if o.log == nil {
+		log, err := newLogger(o.LogOutput, o.LogLevel, o.LogFormat)
+		if err != nil {
+			return err
+		}
+		o.log = log
+	}
+	if o.state == nil {
+		o.state = newExporterState(o.Cluster != "" || len(o.Clusters) > 0, o.MetricFilter)
+	}
+	for _, c := range o.Clusters {
+		c.log, c.state = o.log, o.state
+	}
+	return nil
+}
+
+// count failed command of zk server; probe targets are arbitrary, so their
+// series would pile up forever, and they aren't counted
+func (o *Options) countError(hostLabels []label, cmd string) {
+	if !o.probe {
+		o.state.scrapeErrors.With(o.state.labels(hostLabels, label{"command", cmd})).Inc()
+	}
+}
+
+// observe duration of successful command of zk server, except of probe targets
+func (o *Options) observeCommand(hostLabels []label, cmd string, d time.Duration) {
+	if !o.probe {
+		o.state.commandDuration.With(o.state.labels(hostLabels, label{"command", cmd})).Observe(d.Seconds())
+	}
+}
+
+// whether 'mntr' or its counterpart, AdminServer 'monitor' or metrics provider endpoint,
+// is scraped from zk server, host is 'host:port' as configured
+func (o *Options) scrapesMntr(host string) bool {
+	if o.AdminPort != 0 || o.MetricsPort != 0 || o.isAdminHost(host) {
+		return true
+	}
+	return containsString(o.hostCommands(host), "mntr")
+}
+
+// 4lw commands executed on zk server, as set for the host in -config file,
+// or Commands; host is 'host:port' as configured
+func (o *Options) hostCommands(host string) []string {
+	if commands, ok := o.HostCommands[host]; ok {
+		return commands
+	}
+	return o.Commands
+}
+
+// options of cluster zk server belongs to, nil if it isn't configured; with
+// -probe-location any zk server is allowed, the same as it can be probed, and
+// error tells why it can't
+func (o *Options) hostOptions(host string) (*Options, error) {
+	clusters := o.Clusters
+	if len(clusters) == 0 {
+		clusters = []*Options{o}
+	}
+	for _, c := range clusters {
+		if containsString(c.GetHosts(), host) {
+			return c, nil
+		}
+	}
+	if o.ProbeLocation != "" {
+		return o.forProbe(host)
+	}
+	return nil, nil
+}
+
+// options of cluster which consists of probe target only, -config clusters are ignored;
+// target may have 'tcp://' or 'tls://' scheme, which is stripped from Hosts. Targets come
+// from requests, so only zk client ports can be probed: unix sockets of exporter host
+// and AdminServer 'http://' and 'https://' targets are rejected
+func (o *Options) forProbe(target string) (*Options, error) {
+	scheme, host, err := splitHostScheme(target)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == hostSchemeHTTP || scheme == hostSchemeHTTPS {
+		return nil, fmt.Errorf("AdminServer target %q can't be probed, expected 'host:port' of zk client port", target)
+	}
+	n, err := normalizeHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := unixSocketPath(n); ok {
+		return nil, fmt.Errorf("unix socket target %q can't be probed", target)
+	}
+	probeOptions, err := o.forCluster(clusterConfig{Hosts: []string{scheme + n}})
+	if err != nil {
+		return nil, err
+	}
+	probeOptions.probe = true
+	return probeOptions, nil
+}
+
+// tls config of zk server, as set for the host in -config file, or TLSConfig;
+// nil if tls is disabled; host is 'host:port' as configured
+func (o *Options) hostTLS(host string) *tls.Config {
+	o.hostsMu.RLock()
+	defer o.hostsMu.RUnlock()
+	if config, ok := o.HostTLSConfigs[host]; ok {
+		return config
+	}
+	return o.TLSConfig
+}
+
+// whether zk server is scraped from AdminServer at its address, i.e. it's listed with
+// 'http://' or 'https://' scheme; host is 'host:port' as configured
+func (o *Options) isAdminHost(host string) bool {
+	_, ok := o.adminHost(host)
+	return ok
+}
+
+// tls config of AdminServer of zk server listed with 'http://' or 'https://' scheme,
+// nil for 'http://'; false if zk server isn't scraped from AdminServer at its address
+func (o *Options) adminHost(host string) (*tls.Config, bool) {
+	o.hostsMu.RLock()
+	defer o.hostsMu.RUnlock()
+	config, ok := o.AdminHosts[host]
+	return config, ok
+}
+
+// SetAdminHosts replaces zk servers which are scraped from AdminServer, e.g. of hosts file
+func (o *Options) SetAdminHosts(hosts map[string]*tls.Config) {
+	o.hostsMu.Lock()
+	defer o.hostsMu.Unlock()
+	o.AdminHosts = hosts
+}
+
+// SetHostTLSConfigs replaces tls configs of zk servers, e.g. of hosts with scheme
+func (o *Options) SetHostTLSConfigs(configs map[string]*tls.Config) {
+	o.hostsMu.Lock()
+	defer o.hostsMu.Unlock()
+	o.HostTLSConfigs = configs
+}
+
+// build tls config from -config file settings, nil if tls is disabled
+func (o *Options) newConfigTLS(c *clusterTLSConfig) (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	return newTLSConfig(o.log, c.Cert, c.Key, c.CA, c.ServerName, c.Insecure, o.TLSMinVersion, o.TLSCipherSuites)
+}
+
+// timeout for establishing connection, falls back to Timeout
+func (o *Options) connectTimeout() time.Duration {
+	if o.ConnectTimeout > 0 {
+		return o.ConnectTimeout
+	}
+	return o.Timeout
+}
+
+// timeout for reading command response, falls back to Timeout
+func (o *Options) readTimeout() time.Duration {
+	if o.ReadTimeout > 0 {
+		return o.ReadTimeout
+	}
+	return o.Timeout
+}
+
+// build scrape options of cluster from -config file, settings which
+// aren't set for cluster are inherited from flags
+func (o *Options) forCluster(c clusterConfig) (*Options, error) {
+	co := &Options{
+		Timeout:         o.Timeout,
+		ConnectTimeout:  o.ConnectTimeout,
+		ReadTimeout:     o.ReadTimeout,
+		TLSConfig:       o.TLSConfig,
+		SchemeTLS:       o.SchemeTLS,
+		TLSMinVersion:   o.TLSMinVersion,
+		TLSCipherSuites: o.TLSCipherSuites,
+		AdminPort:       o.AdminPort,
+		AdminTLS:        o.AdminTLS,
+		MetricsPort:     o.MetricsPort,
+		HostLabelMode:   o.HostLabelMode,
+		HostAliases:     o.HostAliases,
+		AdminUser:       o.AdminUser,
+		AdminPassword:   o.AdminPassword,
+		Retries:         o.Retries,
+		SkipRuok:        o.SkipRuok,
+		AssumeRuok:      o.AssumeRuok,
+		BreakerFailures: o.BreakerFailures,
+		BreakerProbe:    o.BreakerProbe,
+		ScrapeJitter:    o.ScrapeJitter,
+		KeepAlive:       o.KeepAlive,
+		MaxResponseSize: o.MaxResponseSize,
+		ProxyURL:        o.ProxyURL,
+		ResolveAll:      o.ResolveAll,
+		Commands:        o.Commands,
+		Cluster:         c.Name,
+
+		FullVersionLabel: o.FullVersionLabel,
+		MntrDotLabel:     o.MntrDotLabel,
+		log:              o.log,
+		state:            o.state,
+	}
+
+	if c.Timeout > 0 {
+		co.Timeout = c.Timeout
+		if co.ConnectTimeout == 0 || co.ConnectTimeout > c.Timeout {
+			co.ConnectTimeout = c.Timeout
+		}
+		if co.ReadTimeout == 0 || co.ReadTimeout > c.Timeout {
+			co.ReadTimeout = c.Timeout
+		}
+	}
+
+	if c.MetricsPort != nil {
+		co.MetricsPort = *c.MetricsPort
+	}
+
+	if len(c.Commands) > 0 {
+		co.Commands = c.Commands
+	}
+	if c.TLS != nil {
+		var err error
+		co.TLSConfig, err = o.newConfigTLS(c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		// 'tls://' hosts of cluster use its tls settings, unless tls is disabled
+		if co.TLSConfig != nil {
+			co.SchemeTLS = co.TLSConfig
+		}
+	}
+
+	hosts, schemeConfigs, adminHosts, err := splitHostSchemes(c.Hosts, co.SchemeTLS)
+	if err != nil {
+		return nil, err
+	}
+	co.Hosts = normalizeHosts(hosts, o.log)
+	co.AdminHosts = adminHosts
+	for h, config := range schemeConfigs {
+		if co.HostTLSConfigs == nil {
+			co.HostTLSConfigs = map[string]*tls.Config{}
+		}
+		co.HostTLSConfigs[h] = config
+	}
+
+	// settings of hosts are keyed by normalized host, the same as Hosts;
+	// tls settings of host take precedence over its scheme
+	for h, hc := range c.HostConfigs {
+		_, h, _ := splitHostScheme(h)
+		n, err := normalizeHost(h)
+		if err != nil {
+			n = h
+		}
+		if hc.Commands != nil {
+			if co.HostCommands == nil {
+				co.HostCommands = map[string][]string{}
+			}
+			co.HostCommands[n] = hc.Commands
+		}
+		if hc.TLS != nil {
+			config, err := o.newConfigTLS(hc.TLS)
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %v", h, err)
+			}
+			if co.HostTLSConfigs == nil {
+				co.HostTLSConfigs = map[string]*tls.Config{}
+			}
+			co.HostTLSConfigs[n] = config
+		}
+	}
+	return co, nil
+}
+
+// labels of per-host series: zk_host and, if clusters are set in -config file, cluster
+func (o *Options) hostLabels(host string) []label {
+	if o.Cluster == "" {
+		return []label{{"zk_host", host}}
+	}
+	return []label{{"cluster", o.Cluster}, {"zk_host", host}}
+}
+
+// replace host aliases, e.g. with names of discovered pods
+func (o *Options) SetHostAliases(aliases map[string]string) {
+	o.hostsMu.Lock()
+	defer o.hostsMu.Unlock()
+	o.HostAliases = aliases
+}
+
+// GetHosts returns current list of zk servers
+func (o *Options) GetHosts() []string {
+	o.hostsMu.RLock()
+	defer o.hostsMu.RUnlock()
+	return o.Hosts
+}
+
+// SetHosts replaces list of zk servers
+func (o *Options) SetHosts(hosts []string) {
+	o.hostsMu.Lock()
+	defer o.hostsMu.Unlock()
+	o.Hosts = hosts
+}
+
+// dial zk server over tcp or unix socket, dialing is aborted when ctx is done;
+// tcp connections go through proxy if it's set
+func dial(ctx context.Context, network, addr string, timeout, keepAlive time.Duration, tlsConfig *tls.Config, proxy *url.URL) (net.Conn, error) {
+	if proxy != nil && network == "tcp" {
+		conn, err := dialProxy(ctx, proxy, addr, timeout, keepAlive)
+		if err != nil {
+			return nil, err
+		}
+		setLinger(conn)
+		if tlsConfig == nil {
+			return conn, nil
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		hctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(hctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	dialer := net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	var conn net.Conn
+	var err error
+	if tlsConfig == nil {
+		conn, err = dialer.DialContext(ctx, network, addr)
+	} else {
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	setLinger(conn)
+	return conn, nil
+}
+
+// every connection is used for a single 4lw command, so it's reset on close instead
+// of lingering in FIN_WAIT and TIME_WAIT states, which keeps fds and ports
+// from piling up under high scrape rate
+func setLinger(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+}
+
+// reasons of zk server being down, exported as 'reason' label of zk_connection_error
+const (
+	downReasonResolve        = "resolve"
+	downReasonConnect        = "connect"
+	downReasonTimeout        = "timeout"
+	downReasonNotWhitelisted = "not_whitelisted"
+	downReasonAuth           = "auth"
+	downReasonProxy          = "proxy"
+	downReasonBreakerOpen    = "breaker_open"
+	downReasonError          = "error"
+)
+
+// classify error of zk command: timeouts, connection errors and other failures
+func errorReason(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return downReasonTimeout
+	}
+	if errors.Is(err, errAdminAuth) {
+		return downReasonAuth
+	}
+	var proxyErr *proxyError
+	if errors.As(err, &proxyErr) {
+		return downReasonProxy
+	}
+	var connErr *connError
+	if errors.As(err, &connErr) {
+		return downReasonConnect
+	}
+	return downReasonError
+}
+
+// add series of zk server which can't be connected to or resolved, host is 'host:port' as configured
+func addHostDown(metrics *metricSet, options *Options, host string, hostLabels []label, reason string) {
+	metrics.add("zk_up", hostLabels, "0")
+	if options.scrapesMntr(host) {
+		metrics.add("zk_mntr_scrape_success", hostLabels, "0")
+	}
+	addDownReason(metrics, hostLabels, reason)
+}
+
+// add zk_connection_error series explaining why zk server is down or why
+// 'mntr' failed, the series exists only for hosts which aren't fully scraped
+func addDownReason(metrics *metricSet, hostLabels []label, reason string) {
+	if reason == "" {
+		reason = downReasonError
+	}
+	metrics.add("zk_connection_error", append(hostLabels, label{"reason", reason}), "1")
+}
+
+// reason of 'ruok' response other than 'imok', e.g. of server in error state
+const ruokReasonNotImok = "not_imok"
+
+// set zk_ruok to 0 and add zk_ruok_error series explaining why, which, like
+// zk_connection_error, exists only for failed 'ruok'; reasons are the same as of
+// zk_connection_error, and not_imok for unexpected response
+func addRuokFailure(metrics *metricSet, hostLabels []label, reason string) {
+	if reason == "" {
+		reason = downReasonError
+	}
+	metrics.add("zk_ruok", hostLabels, "0")
+	metrics.add("zk_ruok_error", append(hostLabels, label{"reason", reason}), "1")
+}
+
+// connError is returned when connection to zk server can't be established,
+// as opposed to failures of command on established connection
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+// dial zk server and send command, failed attempts are retried with exponential
+// backoff as long as retry fits into timeout; returns response and number of retries used
+func execZookeeperCmd(ctx context.Context, options *Options, network, addr, host, cmd string, tlsConfig *tls.Config) (string, int, error) {
+	deadline := time.Now().Add(options.Timeout)
+	backoff := retryBackoff
+
+	for retry := 0; ; retry++ {
+		connectTimeout := options.connectTimeout()
+		if left := time.Until(deadline); left < connectTimeout {
+			connectTimeout = left
+		}
+
+		var res string
+		conn, err := dial(ctx, network, addr, connectTimeout, options.KeepAlive, tlsConfig, options.ProxyURL)
+		if err != nil {
+			err = &connError{err}
+		} else {
+			res, err = sendZookeeperCmd(ctx, conn, cmd, options.readTimeout(), options.MaxResponseSize)
+			if err == nil && res == "" {
+				err = fmt.Errorf("empty '%s' response", cmd)
+			}
+			if err == nil {
+				return res, retry, nil
+			}
+		}
+
+		// oversized response won't get smaller on retry
+		if retry >= options.Retries || time.Now().Add(backoff).After(deadline) || ctx.Err() != nil || errors.Is(err, errResponseTooLarge) {
+			return "", retry, err
+		}
+
+		options.log.Warn("command failed, retrying", "zk_host", host, "command", cmd, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return "", retry, err
+		}
+		backoff *= 2
+	}
+}
+
+// open tcp connections to zk nodes concurrently, send 'mntr' and return result as a metric set
+func getMetrics(ctx context.Context, options *Options) (metrics *metricSet) {
+	metrics = newMetricSet()
+	metrics.filter = options.MetricFilter
+	defer recoverScrape(options, metrics)
+	start := time.Now()
+
+	clusters := options.Clusters
+	if len(clusters) == 0 {
+		clusters = []*Options{options}
+	}
+
+	// limits number of hosts scraped at once across all clusters
+	limiter := newScrapeLimiter(options.MaxConcurrency)
+
+	var wg sync.WaitGroup
+	for _, c := range clusters {
+		wg.Add(1)
+		go func(c *Options) {
+			defer wg.Done()
+			defer recoverScrape(options, metrics)
+			scrapeCluster(ctx, c, metrics, limiter)
+		}(c)
+	}
+	wg.Wait()
+	metrics.add("zk_exporter_scrape_inflight", nil, strconv.Itoa(limiter.peak))
+
+	// exporter itself is up even if none of zk servers is
+	metrics.add("zk_exporter_up", nil, "1")
+	addBuildInfo(metrics)
+	addProcessMetrics(metrics)
+	metrics.add("zk_exporter_scrape_duration_seconds", nil, formatSeconds(time.Since(start)))
+	return metrics
+}
+
+// recover from panic of goroutine which scrapes zk servers, so that weird zk response
+// doesn't kill exporter; metric set is marked as incomplete, to be served with status 500;
+// kv are logged along with panic, e.g. zk_host of per-host scrape
+func recoverScrape(options *Options, metrics *metricSet, kv ...interface{}) {
+	if r := recover(); r != nil {
+		options.state.scrapePanics.Inc()
+		options.log.Error("recovered from panic while scraping", append(kv, "panic", r, "stack", string(debug.Stack()))...)
+		metrics.setPanicked()
+	}
+}
+
+// add number of goroutines and open file descriptors of exporter, counted after
+// all zk servers are scraped, so that growth of them means leaked connections
+func addProcessMetrics(metrics *metricSet) {
+	metrics.add("zk_exporter_goroutines", nil, strconv.Itoa(runtime.NumGoroutine()))
+	// file descriptors can be counted only where procfs is available, i.e. on linux
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		metrics.add("zk_exporter_open_fds", nil, strconv.Itoa(len(fds)))
+	}
+}
+
+// scrape single zk server passed to probe location, it's scraped with the same settings
+// as configured zk servers, but without cluster label and exporter-wide metrics;
+// zk_probe_success tells whether it's up. Error tells why target can't be probed
+func scrapeProbe(ctx context.Context, options *Options, addr string) (*metricSet, error) {
+	probeOptions, err := options.forProbe(addr)
+	if err != nil {
+		return nil, err
+	}
+	metrics := newMetricSet()
+	metrics.filter = options.MetricFilter
+	start := time.Now()
+
+	host := probeOptions.Hosts[0]
+	t := target{host: host, name: host}
+	t.label = probeOptions.hostLabel(t)
+	scrapeHost(ctx, probeOptions, t, metrics)
+
+	if up, _ := metrics.value("zk_up", probeOptions.hostLabels(t.label)); up == "1" {
+		metrics.add("zk_probe_success", nil, "1")
+	} else {
+		metrics.add("zk_probe_success", nil, "0")
+	}
+	metrics.add("zk_exporter_scrape_duration_seconds", nil, formatSeconds(time.Since(start)))
+	return metrics, nil
+}
+
+// scrapeLimiter limits number of concurrent host scrapes to -max-concurrency
+// and tracks peak number of them, to tell whether the limit holds scrapes back
+type scrapeLimiter struct {
+	slots chan struct{} // nil if there's no limit
+
+	mu       sync.Mutex
+	inflight int
+	peak     int
+}
+
+func newScrapeLimiter(max int) *scrapeLimiter {
+	l := &scrapeLimiter{}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire waits for free slot and returns time spent waiting, false if ctx is done first
+func (l *scrapeLimiter) acquire(ctx context.Context) (time.Duration, bool) {
+	start := time.Now()
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return time.Since(start), false
+		}
+	}
+	wait := time.Since(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight++
+	if l.inflight > l.peak {
+		l.peak = l.inflight
+	}
+	return wait, true
+}
+
+func (l *scrapeLimiter) release() {
+	l.mu.Lock()
+	l.inflight--
+	l.mu.Unlock()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// scrape all zk servers of cluster concurrently and add cluster-wide metrics,
+// limiter bounds number of concurrent host scrapes
+func scrapeCluster(ctx context.Context, options *Options, metrics *metricSet, limiter *scrapeLimiter) {
+	var wg sync.WaitGroup
+	scrape := func(t target) {
+		defer wg.Done()
+		hostLabels := options.hostLabels(t.label)
+		// dead servers are skipped between probes, so they don't slow down scrapes
+		key := series{labels: hostLabels}.labelsID()
+		if !options.state.breaker.allow(options, key) {
+			addHostDown(metrics, options, t.name, hostLabels, downReasonBreakerOpen)
+			metrics.add("zk_exporter_breaker_open", hostLabels, "1")
+			return
+		}
+		if options.BreakerFailures > 0 {
+			defer func() {
+				// canceled scrape doesn't tell whether server is up
+				if up, ok := metrics.value("zk_up", hostLabels); ok && ctx.Err() == nil {
+					options.state.breaker.record(key, up == "1")
+				}
+				if options.state.breaker.isOpen(options, key) {
+					metrics.add("zk_exporter_breaker_open", hostLabels, "1")
+				} else {
+					metrics.add("zk_exporter_breaker_open", hostLabels, "0")
+				}
+			}()
+		}
+		// spread connections of exporter replicas scraping at aligned intervals;
+		// jitter is waited before taking a slot, so it doesn't hold back other hosts
+		if options.ScrapeJitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(options.ScrapeJitter)))):
+			case <-ctx.Done():
+				return
+			}
+		}
+		wait, ok := limiter.acquire(ctx)
+		if !ok {
+			return
+		}
+		defer limiter.release()
+		if limiter.slots != nil {
+			metrics.add("zk_exporter_scrape_queue_wait_seconds", hostLabels, formatSeconds(wait))
+		}
+		defer func(start time.Time) {
+			metrics.add("zk_exporter_scrape_duration_seconds", hostLabels, formatSeconds(time.Since(start)))
+		}(time.Now())
+		defer recoverScrape(options, metrics, "zk_host", t.host)
+		scrapeHost(ctx, options, t, metrics)
+	}
+
+	// hostnames may resolve to the same address, e.g. 'zk' and 'zk-0', which would
+	// be scraped twice; it's logged at debug level, as it's repeated on every scrape
+	var resolvedMu sync.Mutex
+	resolved := map[string]bool{}
+
+	hosts := options.GetHosts()
+	for _, h := range hosts {
+		if !options.ResolveAll {
+			wg.Add(1)
+			t := target{host: h, name: h}
+			t.label = options.hostLabel(t)
+			go scrape(t)
+			continue
+		}
+
+		wg.Add(1)
+		go func(h string) {
+			defer wg.Done()
+			defer recoverScrape(options, metrics)
+			targets, err := resolveTargets(h)
+			if err != nil {
+				options.state.resolveFailures.Inc()
+				options.log.Warn("cannot resolve zk hostname", "zk_host", h, "error", err)
+				hostLabels := options.hostLabels(options.hostLabel(target{host: h, name: h}))
+				addHostDown(metrics, options, h, hostLabels, downReasonResolve)
+				return
+			}
+			for _, t := range targets {
+				resolvedMu.Lock()
+				duplicate := resolved[t.host]
+				resolved[t.host] = true
+				resolvedMu.Unlock()
+				if duplicate {
+					options.log.Debug("zk server address is already scraped", "zk_host", h, "address", t.host)
+					continue
+				}
+				t.label = options.hostLabel(t)
+				wg.Add(1)
+				go scrape(t)
+			}
+		}(h)
+	}
+	wg.Wait()
+
+	addClusterMetrics(metrics, options, len(hosts))
+	addCertExpiry(metrics, options)
+}
+
+// add expiry time of zk tls client certificates of cluster and of its hosts which have their
+// own certificates, so that they can be renewed in time; certificate becomes invalid while
+// exporter is running, so it's checked on every scrape
+func addCertExpiry(metrics *metricSet, options *Options) {
+	var clusterLabels []label
+	if options.Cluster != "" {
+		clusterLabels = []label{{"cluster", options.Cluster}}
+	}
+	add := func(config *tls.Config, labels []label) {
+		if config == nil || len(config.Certificates) == 0 {
+			return
+		}
+		// certificate which expired while exporter is running has expiry time in the past
+		expiry, _ := certExpiry(config.Certificates[0])
+		if expiry.IsZero() {
+			return
+		}
+		metrics.add("zk_exporter_client_cert_expiry_timestamp_seconds", labels, strconv.FormatInt(expiry.Unix(), 10))
+	}
+
+	add(options.TLSConfig, clusterLabels)
+	for h, config := range options.HostTLSConfigs {
+		// 'tls://' hosts share certificate of cluster
+		if config == options.TLSConfig {
+			continue
+		}
+		add(config, options.hostLabels(options.hostLabel(target{host: h, name: h})))
+	}
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// target is a single zk server to scrape
+type target struct {
+	host  string // 'host:port' to connect to
+	name  string // 'host:port' as configured, used for tls server name
+	label string // value of zk_host label, see -host-label-mode
+}
+
+// add number of configured and up zk servers of cluster, and number of leaders
+// and followers, e.g. to alert on split-brain or lack of leader; servers which
+// are in leader only state and don't serve client requests have zk_server_leader
+// set, so they count as leaders; every leader gets zk_ensemble_leader, so that
+// all of them are visible during split-brain
+func addClusterMetrics(metrics *metricSet, options *Options, hosts int) {
+	up := 0
+	// host labels of leaders, keyed by zk_host
+	leaders := map[string][]label{}
+	followers := map[string]bool{}
+	for _, s := range metrics.sorted() {
+		if labelValue(s.labels, "cluster") != options.Cluster {
+			continue
+		}
+		host := labelValue(s.labels, "zk_host")
+		switch {
+		case s.name == "zk_up" && s.value == "1":
+			up++
+		case s.name == "zk_server_leader" && s.value == "1":
+			leaders[host] = s.labels
+		case s.name == "zk_server_role" && labelValue(s.labels, "role") == "follower":
+			followers[host] = true
+		}
+	}
+
+	var clusterLabels []label
+	if options.Cluster != "" {
+		clusterLabels = []label{{"cluster", options.Cluster}}
+	}
+	metrics.add("zk_ensemble_leaders_total", clusterLabels, strconv.Itoa(len(leaders)))
+	for _, hostLabels := range leaders {
+		metrics.add("zk_ensemble_leader", hostLabels, "1")
+	}
+	metrics.add("zk_ensemble_followers_total", clusterLabels, strconv.Itoa(len(followers)))
+	metrics.add("zk_exporter_hosts_total", clusterLabels, strconv.Itoa(hosts))
+	metrics.add("zk_exporter_hosts_up", clusterLabels, strconv.Itoa(up))
+}
+
+// resolve hostname into all its addresses, so that every server
+// behind a name like k8s headless service is scraped individually
+func resolveTargets(h string) ([]target, error) {
+	if _, ok := unixSocketPath(h); ok {
+		return []target{{host: h, name: h}}, nil
+	}
+
+	host, port, err := net.SplitHostPort(h)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]target, 0, len(addrs))
+	for _, addr := range addrs {
+		targets = append(targets, target{host: net.JoinHostPort(addr, port), name: h})
+	}
+	return targets, nil
+}
+
+// send configured 4lw commands to a single zk node and add results to metrics
+func scrapeHost(ctx context.Context, options *Options, t target, metrics *metricSet) {
+	h := t.host
+
+	hostLabels := options.hostLabels(t.label)
+
+	// unix socket paths don't need resolution, hostnames are resolved by proxy if it's set
+	network, addr := "tcp", ""
+	if path, ok := unixSocketPath(h); ok {
+		network, addr = "unix", path
+	} else if options.ProxyURL != nil {
+		addr = h
+	} else {
+		tcpaddr, err := net.ResolveTCPAddr("tcp", h)
+		if err != nil {
+			options.state.resolveFailures.Inc()
+			options.log.Warn("cannot resolve zk hostname", "zk_host", h, "error", err)
+			addHostDown(metrics, options, t.name, hostLabels, downReasonResolve)
+			return
+		}
+		addr = tcpaddr.String()
+	}
+
+	// 'http://' host is AdminServer address, whatever other zk servers are scraped with
+	if adminTLS, ok := options.adminHost(t.name); ok {
+		scrapeAdminServer(ctx, options, h, h, hostTLSConfig(adminTLS, t.name), hostLabels, metrics)
+		return
+	}
+	if options.MetricsPort != 0 {
+		scrapeMetricsProvider(ctx, options, h, hostLabels, metrics)
+		return
+	}
+	if options.AdminPort != 0 {
+		host, _, err := net.SplitHostPort(h)
+		if err != nil {
+			host = h
+		}
+		adminAddr := net.JoinHostPort(host, strconv.Itoa(options.AdminPort))
+		scrapeAdminServer(ctx, options, h, adminAddr, hostTLSConfig(options.AdminTLS, t.name), hostLabels, metrics)
+		return
+	}
+
+	tlsConfig := hostTLSConfig(options.hostTLS(t.name), t.name)
+
+	// zk is considered up if connection to it was established, even if
+	// commands failed; 'mntr' success is reported separately
+	connected := false
+	mntrOK := false
+	retries := 0
+	// reason of connection failure, reported if zk isn't up
+	reason := ""
+	// reason of 'mntr' failure, failures of other commands don't tell why it failed
+	mntrReason := ""
+	defer func() {
+		metrics.add("zk_exporter_retries", hostLabels, strconv.Itoa(retries))
+		// canceled scrape doesn't tell whether server is up
+		if !connected && ctx.Err() != nil {
+			return
+		}
+		if !connected {
+			addHostDown(metrics, options, t.name, hostLabels, reason)
+			return
+		}
+		metrics.add("zk_up", hostLabels, "1")
+		if options.scrapesMntr(t.name) {
+			if mntrOK {
+				metrics.add("zk_mntr_scrape_success", hostLabels, "1")
+			} else {
+				metrics.add("zk_mntr_scrape_success", hostLabels, "0")
+				if mntrReason != "" {
+					addDownReason(metrics, hostLabels, mntrReason)
+				}
+			}
+		}
+	}()
+
+	// commands may be extended while scraping, e.g. with 'srvr' for leader only servers
+	commands := options.hostCommands(t.name)
+	for i := 0; i < len(commands); i++ {
+		cmd := commands[i]
+		if cmd == "ruok" && options.SkipRuok {
+			continue
+		}
+		// successful 'mntr' proves server is responsive, spare connection
+		if cmd == "ruok" && mntrOK && options.AssumeRuok {
+			metrics.add("zk_ruok", hostLabels, "1")
+			continue
+		}
+
+		start := time.Now()
+		res, cmdRetries, err := execZookeeperCmd(ctx, options, network, addr, h, cmd, tlsConfig)
+		retries += cmdRetries
+		// scrape was canceled, e.g. http client disconnected
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			options.countError(hostLabels, cmd)
+			reason = errorReason(err)
+			// server is unreachable, don't waste time on other commands
+			var connErr *connError
+			if errors.As(err, &connErr) && !connected {
+				options.log.Warn("cannot connect", "zk_host", h, "error", err)
+				return
+			}
+			if cmd == "mntr" {
+				mntrReason = reason
+			}
+			// command failed on established connection, e.g. timed out
+			options.log.Warn("command failed", "zk_host", h, "command", cmd, "error", err)
+			connected = true
+			if cmd == "ruok" {
+				addRuokFailure(metrics, hostLabels, reason)
+			}
+			continue
+		}
+		connected = true
+		options.observeCommand(hostLabels, cmd, time.Since(start))
+		// some builds and proxies terminate lines with CRLF, parsers expect LF
+		res = strings.Replace(res, "\r\n", "\n", -1)
+
+		// instance is in a leader only state and doesnt serving client requets
+		if strings.HasPrefix(res, instanceNotServingMessage) {
+			metrics.add("zk_not_serving", hostLabels, "1")
+			metrics.add("zk_server_leader", hostLabels, "1")
+			metrics.addInfo("zk_server_state", hostLabels, label{"state", "leader"})
+			metrics.addInfo("zk_server_role", hostLabels, label{"role", "leader"})
+			// try to recover basic stats with 'srvr', unless it's already configured;
+			// full slice expression makes append copy commands instead of modifying options
+			if !containsString(commands, "srvr") && !containsString(commands, "stat") {
+				commands = append(commands[:len(commands):len(commands)], "srvr")
+			}
+			continue
+		}
+
+		// command isn't allowed in zk config, log as a warning
+		if strings.Contains(res, cmdNotExecutedSffx) {
+			options.countError(hostLabels, cmd)
+			if cmd == "mntr" {
+				mntrReason = downReasonNotWhitelisted
+			}
+			options.state.notWhitelisted.WithLabelValues(cmd).Inc()
+			options.log.Warn(commandNotAllowedMessage, "zk_host", h, "command", cmd)
+			if cmd == "ruok" {
+				addRuokFailure(metrics, hostLabels, downReasonNotWhitelisted)
+			}
+			continue
+		}
+
+		if err := commandParsers[cmd](options, res, hostLabels, metrics); err != nil {
+			options.countError(hostLabels, cmd)
+			if cmd == "mntr" {
+				mntrReason = downReasonError
+			}
+			options.log.Warn("cannot parse command response", "zk_host", h, "command", cmd, "error", err)
+			continue
+		}
+		if cmd == "mntr" {
+			mntrOK = true
+		}
+	}
+}
+
+// convert single 'mntr' key-value pair into metric
+func addMntrMetric(options *Options, key, value string, hostLabels []label, metrics *metricSet) {
+	switch key {
+	// state, e.g. leader, follower, observer, standalone or read-only, as info metric which
+	// can be counted by state; role and leader flag are kept for compatibility
+	case "zk_server_state":
+		metrics.addInfo("zk_server_state", hostLabels, label{"state", value})
+		metrics.addInfo("zk_server_role", hostLabels, label{"role", value})
+		if value == "leader" {
+			metrics.add("zk_server_leader", hostLabels, "1")
+		} else {
+			metrics.add("zk_server_leader", hostLabels, "0")
+		}
+
+	case "zk_version":
+		version := "unknown"
+		if m := versionRE.FindStringSubmatch(value); m != nil {
+			version = m[1]
+		}
+		labels := append(hostLabels, label{"version", version})
+		if options.FullVersionLabel {
+			labels = append(labels, label{"full_version", value})
+		}
+		metrics.add("zk_version", labels, "1")
+
+	case "zk_peer_state":
+		metrics.add("zk_peer_state", append(hostLabels, label{"state", value}), "1")
+
+	default:
+		number, ok := parseNumber(value)
+		if !ok {
+			options.state.nonDigitSkipped.Inc()
+			options.log.Debug("skipping metric which holds not-digit value", "zk_host", labelValue(hostLabels, "zk_host"), "metric", key, "value", value)
+			return
+		}
+
+		name, labels, err := parseMetricKey(key)
+		if err != nil {
+			options.log.Warn("skipping metric", "zk_host", labelValue(hostLabels, "zk_host"), "metric", key, "error", err)
+			return
+		}
+		// e.g. 'zk_write_per_namespace.solrcloud7' becomes 'zk_write_per_namespace{namespace="solrcloud7"}'
+		if i := strings.Index(name, "."); options.MntrDotLabel != "" && i > 0 && i < len(name)-1 {
+			name, labels = name[:i], append(labels, label{options.MntrDotLabel, name[i+1:]})
+		}
+		name, number = runtimeMetric(name, number, metrics)
+		if metrics.allowed(name) {
+			metrics.add(name, append(labels, hostLabels...), number)
+		}
+		addSecondsMetric(name, append(labels, hostLabels...), number, metrics)
+	}
+}
+
+// add duration metric which 'mntr' reports in milliseconds converted to seconds, with
+// '_seconds' suffix instead of '_ms', e.g. 'zk_uptime_seconds'; original metric is kept
+// as is, with help which tells it's deprecated in favor of the converted one
+func addSecondsMetric(name string, labels []label, number string, metrics *metricSet) {
+	if !millisecondsRE.MatchString(name) {
+		return
+	}
+	seconds := strings.TrimSuffix(name, "_ms") + "_seconds"
+	ms, err := strconv.ParseFloat(number, 64)
+	if err != nil || !metrics.allowed(seconds) {
+		return
+	}
+	// help of known metrics, e.g. zk_uptime, is deprecated in knownMetrics
+	info := lookupMetricInfo(name)
+	metrics.setInfo(name, metricInfo{info.typ, fmt.Sprintf("Deprecated, use %s. %s", seconds, info.help)})
+	metrics.setInfo(seconds, metricInfo{"gauge", fmt.Sprintf("Zookeeper metric %s, converted to seconds.", name)})
+	metrics.add(seconds, labels, strconv.FormatFloat(ms/1000, 'g', -1, 64))
+}
+
+// jvm and process metrics which some zk distributions add to 'mntr' with or without
+// 'zk_' prefix, keyed by name without it, mapped to names with units, which are typed
+// in knownMetrics; values are multiplied by scale unless it's 0, e.g. to get seconds
+var runtimeMetrics = map[string]struct {
+	name  string
+	scale float64
+}{
+	"jvm_heap_used":                 {"zk_jvm_heap_used_bytes", 0},
+	"jvm_heap_committed":            {"zk_jvm_heap_committed_bytes", 0},
+	"jvm_heap_max":                  {"zk_jvm_heap_max_bytes", 0},
+	"jvm_nonheap_used":              {"zk_jvm_nonheap_used_bytes", 0},
+	"jvm_threads_count":             {"zk_jvm_threads", 0},
+	"jvm_threads_daemon_count":      {"zk_jvm_threads_daemon", 0},
+	"jvm_gc_collection_count":       {"zk_jvm_gc_collections_total", 0},
+	"jvm_gc_collection_time_ms":     {"zk_jvm_gc_collection_seconds_total", 0.001},
+	"process_open_fds":              {"zk_process_open_fds", 0},
+	"process_max_fds":               {"zk_process_max_fds", 0},
+	"process_cpu_seconds_total":     {"zk_process_cpu_seconds_total", 0},
+	"process_resident_memory_bytes": {"zk_process_resident_memory_bytes", 0},
+	"process_start_time_seconds":    {"zk_process_start_time_seconds", 0},
+}
+
+// rename jvm or process metric reported by 'mntr' and convert its value: metrics of
+// runtimeMetrics get their names, e.g. 'jvm_heap_used' becomes 'zk_jvm_heap_used_bytes',
+// other 'jvm_' and 'process_' metrics get 'zk_' prefix if they lack it and are gauges;
+// other metrics are returned as is
+func runtimeMetric(name, number string, metrics *metricSet) (string, string) {
+	base := strings.TrimPrefix(name, "zk_")
+	if !strings.HasPrefix(base, "jvm_") && !strings.HasPrefix(base, "process_") {
+		return name, number
+	}
+	m, ok := runtimeMetrics[base]
+	if !ok {
+		name = "zk_" + base
+		metrics.setInfo(name, metricInfo{"gauge", fmt.Sprintf("Zookeeper jvm or process metric %s, reported by 'mntr'.", base)})
+		return name, number
+	}
+	if m.scale != 0 {
+		if f, err := strconv.ParseFloat(number, 64); err == nil {
+			number = strconv.FormatFloat(f*m.scale, 'g', -1, 64)
+		}
+	}
+	return m.name, number
+}
+
+// parse numeric value, e.g. '5', '+5', '1.0' or '1e3', and return it in canonical form;
+// integers are kept as is to not lose precision of large counters
+func parseNumber(in string) (string, bool) {
+	if i, err := strconv.ParseInt(in, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), true
+	}
+	if u, err := strconv.ParseUint(in, 10, 64); err == nil {
+		return strconv.FormatUint(u, 10), true
+	}
+	f, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
+// send command and read response, which zk server terminates by closing connection;
+// deadline prevents hanging on half-open connections, on timeout partial response
+// is returned along with error
+func sendZookeeperCmd(ctx context.Context, conn net.Conn, cmd string, timeout time.Duration, maxBytes int64) (string, error) {
+	defer conn.Close()
+
+	// write and read share the deadline, so that peer which doesn't read the
+	// command, e.g. with full receive window, doesn't stall scrape either; it's
+	// set only once, before ctx is watched, so that it can't override deadline
+	// set when ctx is done
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	// unblock pending write or read as soon as ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", fmt.Errorf("failed to send '%s': %w", cmd, err)
+	}
+
+	// read one byte over the limit to tell apart response of exactly maxBytes
+	var r io.Reader = conn
+	if maxBytes > 0 {
+		r = io.LimitReader(conn, maxBytes+1)
+	}
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(res), fmt.Errorf("failed to read '%s' response: %w", cmd, err)
+	}
+	if maxBytes > 0 && int64(len(res)) > maxBytes {
+		return "", fmt.Errorf("'%s' %w of %d bytes", cmd, errResponseTooLarge, maxBytes)
+	}
+
+	return string(res), nil
+}
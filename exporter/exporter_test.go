@@ -0,0 +1,278 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"5", "5", true},
+		{"+5", "5", true},
+		{"-2", "-2", true},
+		{"1.0", "1", true},
+		{"0.5", "0.5", true},
+		{"1e3", "1000", true},
+		{"2.5E-3", "0.0025", true},
+		{"18446744073709551615", "18446744073709551615", true},
+		{"9223372036854775807", "9223372036854775807", true},
+		{"abc", "", false},
+		{"10ms", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseNumber(tt.in)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseNumber(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// non-numeric 'mntr' values are skipped, the rest of response is kept
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	metrics := newMetricSet()
+	addMntrMetric(newTestOptions(), "zk_bad", "abc", hostLabels, metrics)
+	addMntrMetric(newTestOptions(), "zk_avg_latency", "+0.50", hostLabels, metrics)
+	if _, ok := metrics.value("zk_bad", hostLabels); ok {
+		t.Error("non-numeric zk_bad is exported")
+	}
+	if v, _ := metrics.value("zk_avg_latency", hostLabels); v != "0.5" {
+		t.Errorf("zk_avg_latency = %q, want 0.5", v)
+	}
+}
+
+// stalledConn is a connection to peer which doesn't read, its Write blocks until
+// write deadline; Read records that it was called
+type stalledConn struct {
+	net.Conn
+	writeDeadline time.Time
+	read          bool
+}
+
+func (c *stalledConn) Write(b []byte) (int, error) {
+	time.Sleep(time.Until(c.writeDeadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (c *stalledConn) Read(b []byte) (int, error) {
+	c.read = true
+	return 0, io.EOF
+}
+
+func (c *stalledConn) Close() error                       { return nil }
+func (c *stalledConn) SetDeadline(t time.Time) error      { c.writeDeadline = t; return nil }
+func (c *stalledConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *stalledConn) SetWriteDeadline(t time.Time) error { c.writeDeadline = t; return nil }
+
+func TestSendZookeeperCmdWriteTimeout(t *testing.T) {
+	conn := &stalledConn{}
+	start := time.Now()
+	_, err := sendZookeeperCmd(context.Background(), conn, "mntr", 50*time.Millisecond, 0)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if conn.writeDeadline.IsZero() {
+		t.Error("write deadline isn't set")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stalled write returned after %s", elapsed)
+	}
+	if conn.read {
+		t.Error("response is read after failed write")
+	}
+	if reason := errorReason(err); reason != downReasonTimeout {
+		t.Errorf("reason = %q, want %q", reason, downReasonTimeout)
+	}
+}
+
+// cancelingConn cancels scrape once command is written, before response is read
+type cancelingConn struct {
+	net.Conn
+	cancel context.CancelFunc
+}
+
+func (c *cancelingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.cancel()
+	// let ctx watcher set its deadline before response is read
+	time.Sleep(20 * time.Millisecond)
+	return n, err
+}
+
+func TestSendZookeeperCmdCanceledBeforeRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	// server reads command and never responds
+	go io.Copy(ioutil.Discard, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	_, err := sendZookeeperCmd(ctx, &cancelingConn{Conn: client, cancel: cancel}, "mntr", 5*time.Second, 0)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled read returned after %s", elapsed)
+	}
+}
+
+func TestVersionLabel(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"3.4.10-39d3a4f269333c922ed3db283be479f9deacaa0f, built on 03/23/2017 10:13 GMT", "3.4.10"},
+		{"3.6.3--6401e4ad2087061bc6b9f80dec2d69f2e3c8660a, built on 04/08/2021 16:35 GMT", "3.6.3"},
+		{"3.8", "3.8"},
+		{"3.9.0-SNAPSHOT", "3.9.0-SNAPSHOT"},
+		{"3.5.0-alpha", "3.5.0-alpha"},
+		{"3.5.5-rc1", "3.5.5-rc1"},
+		{"3.4.5.1", "3.4.5.1"},
+		{"3.4.6-mapr-1604", "3.4.6"},
+		{"vendor-zk-v3.7.1, built on 01/01/2022", "3.7.1"},
+		{"garbage", "unknown"},
+		{"", "unknown"},
+		{"1.2.3.4.5", "unknown"},
+	}
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	for _, tt := range tests {
+		metrics := newMetricSet()
+		addMntrMetric(newTestOptions(), "zk_version", tt.value, hostLabels, metrics)
+		var versions []string
+		for _, s := range metrics.sorted() {
+			if s.name == "zk_version" {
+				versions = append(versions, labelValue(s.labels, "version"))
+			}
+		}
+		if len(versions) != 1 || versions[0] != tt.want {
+			t.Errorf("zk_version %q: version label = %q, want %q", tt.value, versions, tt.want)
+		}
+	}
+}
+
+func TestServerStateInfo(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	metrics := newMetricSet()
+	// 'mntr' and 'srvr' of the same scrape may disagree, e.g. during leader election
+	addMntrMetric(newTestOptions(), "zk_server_state", "leader", hostLabels, metrics)
+	addMntrMetric(newTestOptions(), "zk_server_state", "follower", hostLabels, metrics)
+
+	var states []string
+	for _, s := range metrics.sorted() {
+		if s.name == "zk_server_state" {
+			states = append(states, labelValue(s.labels, "state")+"="+s.value)
+		}
+	}
+	if len(states) != 1 || states[0] != "follower=1" {
+		t.Errorf("zk_server_state series = %v, want [follower=1]", states)
+	}
+	if v, _ := seriesValue(metrics, "zk_server_leader", hostLabels); v != "0" {
+		t.Errorf("zk_server_leader = %q, want 0", v)
+	}
+}
+
+func TestAddSecondsMetric(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		key, value string
+		name       string // name of converted metric, empty if it isn't converted
+		seconds    string
+	}{
+		{"zk_uptime", "123456", "zk_uptime_seconds", "123.456"},
+		{"zk_avg_latency", "0.5", "zk_avg_latency_seconds", "0.0005"},
+		{"zk_max_latency", "12", "zk_max_latency_seconds", "0.012"},
+		{"zk_avg_local_write_committed_time_ms", "2.5", "zk_avg_local_write_committed_time_seconds", "0.0025"},
+		{"zk_min_local_write_committed_time_ms", "0", "zk_min_local_write_committed_time_seconds", "0"},
+		{"zk_max_local_write_committed_time_ms", "250", "zk_max_local_write_committed_time_seconds", "0.25"},
+		{"zk_sum_local_write_committed_time_ms", "1500", "zk_sum_local_write_committed_time_seconds", "1.5"},
+		{"zk_cnt_local_write_committed_time_ms", "250", "", ""},
+		{"zk_p99_local_write_committed_time_ms", "250", "", ""},
+		{"zk_znode_count", "5", "", ""},
+	}
+
+	for _, tt := range tests {
+		metrics := newMetricSet()
+		addMntrMetric(newTestOptions(), tt.key, tt.value, hostLabels, metrics)
+
+		if got, ok := seriesValue(metrics, tt.key, hostLabels); !ok || got != tt.value {
+			t.Errorf("%s: original metric = %q, %v, want %q", tt.key, got, ok, tt.value)
+		}
+		if tt.name == "" {
+			if n := len(metrics.sorted()); n != 1 {
+				t.Errorf("%s: got %d series, want only original one", tt.key, n)
+			}
+			continue
+		}
+		got, ok := seriesValue(metrics, tt.name, hostLabels)
+		if !ok || got != tt.seconds {
+			t.Errorf("%s: %s = %q, %v, want %q", tt.key, tt.name, got, ok, tt.seconds)
+		}
+		if typ := metrics.lookupInfo(tt.name).typ; typ != "gauge" {
+			t.Errorf("%s: %s has type %q, want gauge", tt.key, tt.name, typ)
+		}
+		if help := metrics.lookupInfo(tt.key).help; !strings.HasPrefix(help, "Deprecated, use "+tt.name+".") {
+			t.Errorf("%s: help %q doesn't deprecate it in favor of %s", tt.key, help, tt.name)
+		}
+	}
+}
+
+func TestFlagEnvName(t *testing.T) {
+	tests := []struct {
+		flag, env string
+	}{
+		{"zk-hosts", "ZK_EXPORTER_ZK_HOSTS"},
+		{"timeout", "ZK_EXPORTER_TIMEOUT"},
+		{"metric-prefix", "ZK_EXPORTER_METRIC_PREFIX"},
+		{"zk-admin-port", "ZK_EXPORTER_ZK_ADMIN_PORT"},
+	}
+	for _, tt := range tests {
+		if env := flagEnvName(tt.flag); env != tt.env {
+			t.Errorf("flagEnvName(%q) = %q, want %q", tt.flag, env, tt.env)
+		}
+	}
+}
+
+func TestForProbe(t *testing.T) {
+	options := newTestOptions()
+	options.ProbeLocation = "/probe"
+	tests := []struct {
+		target string
+		host   string // empty if target is rejected
+	}{
+		{"10.0.0.1", "10.0.0.1:2181"},
+		{"tls://10.0.0.1:2281", "10.0.0.1:2281"},
+		{"unix:///var/run/zookeeper.sock", ""},
+		{"http://10.0.0.1:8080", ""},
+		{"https://10.0.0.1:8443", ""},
+		{"udp://10.0.0.1:2181", ""},
+		{"10.0.0.1:99999", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		probeOptions, err := options.forProbe(tt.target)
+		if tt.host == "" {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.target, err)
+			continue
+		}
+		if len(probeOptions.Hosts) != 1 || probeOptions.Hosts[0] != tt.host || !probeOptions.probe {
+			t.Errorf("%q: hosts %v, probe %v, want [%s] probe", tt.target, probeOptions.Hosts, probeOptions.probe, tt.host)
+		}
+	}
+	if _, err := options.hostOptions("unix:///var/run/zookeeper.sock"); err == nil {
+		t.Errorf("unix socket which isn't configured: expected error")
+	}
+}
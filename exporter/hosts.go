@@ -0,0 +1,288 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix of zk hosts which are unix socket paths, e.g. 'unix:///var/run/zk/4lw.sock'
+const unixSocketPrefix = "unix://"
+
+// schemes of zk hosts, which select transport of the host regardless of -zk-tls-auth,
+// e.g. 'tls://10.0.0.1:2281', so that plain and tls zk servers can be scraped together;
+// 'http://' and 'https://' hosts are AdminServer addresses, e.g. 'http://10.0.0.1:8080';
+// unix socket entries keep 'unix://' prefix as part of host
+const (
+	hostSchemeTCP   = "tcp://"
+	hostSchemeTLS   = "tls://"
+	hostSchemeHTTP  = "http://"
+	hostSchemeHTTPS = "https://"
+)
+
+// zk client port used when host has no port
+const defaultZookeeperPort = "2181"
+
+// return socket path if zk host is a unix socket
+func unixSocketPath(h string) (string, bool) {
+	if !strings.HasPrefix(h, unixSocketPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(h, unixSocketPrefix), true
+}
+
+// normalize 'host:port' entry: ip addresses are brought to canonical form
+// and ipv6 literals are enclosed in brackets, e.g. '[2001:db8::1]:2181';
+// port defaults to 2181 if omitted and must be in 1-65535 range; unix socket
+// entries are kept as is
+func normalizeHost(h string) (string, error) {
+	h = strings.TrimSpace(h)
+	if path, ok := unixSocketPath(h); ok {
+		if path == "" {
+			return "", fmt.Errorf("missing socket path in %q", h)
+		}
+		return h, nil
+	}
+	host, port, err := net.SplitHostPort(h)
+	if err != nil {
+		// hostname or ip address without port, e.g. 'zk-0' or '2001:db8::1'
+		literal := strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+		if !strings.Contains(h, ":") || net.ParseIP(literal) != nil {
+			host, port, err = literal, defaultZookeeperPort, nil
+		} else {
+			return "", err
+		}
+	}
+	if port == "" {
+		port = defaultZookeeperPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("missing host in address %q", h)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in address %q", port, h)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// values of -host-label-mode flag
+const (
+	hostLabelHostPort = "hostport"
+	hostLabelHost     = "host"
+	hostLabelAlias    = "alias"
+)
+
+// value of zk_host label for target: 'host:port', host without port, or alias
+// of configured or resolved address, falling back to 'host:port' if there's no alias
+func (o *Options) hostLabel(t target) string {
+	switch o.HostLabelMode {
+	case hostLabelHost:
+		if host, _, err := net.SplitHostPort(t.host); err == nil {
+			return host
+		}
+	case hostLabelAlias:
+		o.hostsMu.RLock()
+		defer o.hostsMu.RUnlock()
+		if alias, ok := o.HostAliases[t.host]; ok {
+			return alias
+		}
+		if alias, ok := o.HostAliases[t.name]; ok {
+			return alias
+		}
+	}
+	return t.host
+}
+
+// parse 'host:port=alias' entries of -host-alias flags
+func parseHostAliases(entries []string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, e := range entries {
+		i := strings.LastIndex(e, "=")
+		if i <= 0 || i == len(e)-1 {
+			return nil, fmt.Errorf("expected 'host:port=alias', got %q", e)
+		}
+		host, err := normalizeHost(e[:i])
+		if err != nil {
+			return nil, err
+		}
+		aliases[host] = e[i+1:]
+	}
+	return aliases, nil
+}
+
+// normalize list of zk hosts, empty and duplicate entries are dropped,
+// invalid entries, e.g. with port out of range, are logged and dropped; hostnames
+// are case insensitive, so 'ZK-0:2181' is a duplicate of 'zk-0:2181', the first
+// spelling is kept
+func normalizeHosts(hosts []string, log *leveledLogger) []string {
+	normalized := make([]string, 0, len(hosts))
+	seen := map[string]bool{}
+	var duplicates []string
+	for _, h := range hosts {
+		// empty entries come from trailing or doubled commas, e.g. '10.0.0.1:2181,'
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		n, err := normalizeHost(h)
+		if err != nil {
+			log.Warn("invalid zk host is dropped, expected 'host:port' or '[ipv6]:port'", "zk_host", h, "error", err)
+			continue
+		}
+		// the same server listed twice would produce clashing series,
+		// unlike hostnames, socket paths are case sensitive
+		key := n
+		if _, ok := unixSocketPath(n); !ok {
+			key = strings.ToLower(n)
+		}
+		if seen[key] {
+			duplicates = append(duplicates, strings.TrimSpace(h))
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, n)
+	}
+	if len(duplicates) > 0 {
+		log.Warn("duplicate zk hosts are dropped", "zk_hosts", strings.Join(duplicates, ","))
+	}
+	return normalized
+}
+
+// split scheme of zk host, which is empty if host has none; AdminServer isn't
+// selected by scheme, e.g. 'http://', but with -zk-admin-port
+func splitHostScheme(h string) (string, string, error) {
+	h = strings.TrimSpace(h)
+	for _, scheme := range []string{hostSchemeTCP, hostSchemeTLS, hostSchemeHTTP, hostSchemeHTTPS} {
+		if !strings.HasPrefix(strings.ToLower(h), scheme) {
+			continue
+		}
+		host := h[len(scheme):]
+		// AdminServer has no default port, unlike zk client port
+		if _, _, err := net.SplitHostPort(host); (scheme == hostSchemeHTTP || scheme == hostSchemeHTTPS) && err != nil {
+			return "", host, fmt.Errorf("missing AdminServer port of zk host %q, expected '%shost:port'", h, scheme)
+		}
+		return scheme, host, nil
+	}
+	if i := strings.Index(h, "://"); i > 0 && !strings.HasPrefix(h, unixSocketPrefix) {
+		return "", h, fmt.Errorf("unsupported scheme of zk host %q, expected tcp://, tls://, http://, https:// or unix://", h)
+	}
+	return "", h, nil
+}
+
+// strip schemes of zk hosts and return tls configs of hosts which have scheme, keyed
+// by normalized host: nil for 'tcp://' and tlsConfig for 'tls://'; hosts without
+// scheme use tls config of their cluster, or -zk-tls-* settings; 'http://' and
+// 'https://' hosts are returned as admin hosts instead, which are scraped from
+// AdminServer, along with tls config of https: nil for 'http://' and tlsConfig
+func splitHostSchemes(hosts []string, tlsConfig *tls.Config) ([]string, map[string]*tls.Config, map[string]*tls.Config, error) {
+	stripped := make([]string, 0, len(hosts))
+	var configs, adminHosts map[string]*tls.Config
+	for _, h := range hosts {
+		scheme, host, err := splitHostScheme(h)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stripped = append(stripped, host)
+		if scheme == "" {
+			continue
+		}
+
+		n, err := normalizeHost(host)
+		if err != nil {
+			n = host
+		}
+		if scheme == hostSchemeHTTP || scheme == hostSchemeHTTPS {
+			if adminHosts == nil {
+				adminHosts = map[string]*tls.Config{}
+			}
+			adminHosts[n] = nil
+			if scheme == hostSchemeHTTPS {
+				adminHosts[n] = tlsConfig
+			}
+			continue
+		}
+		if configs == nil {
+			configs = map[string]*tls.Config{}
+		}
+		if scheme == hostSchemeTLS {
+			configs[n] = tlsConfig
+		} else {
+			configs[n] = nil
+		}
+	}
+	return stripped, configs, adminHosts, nil
+}
+
+// read zk hosts file, one 'host:port' per line, empty lines and '#' comments are ignored
+func readHostsFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts found in %s", path)
+	}
+	return hosts, nil
+}
+
+// periodically re-stat zk hosts file and update hosts in options once file is changed
+func watchHostsFile(options *Options, path string, interval time.Duration) {
+	var lastMod time.Time
+	var lastSize int64
+	if fi, err := os.Stat(path); err == nil {
+		lastMod, lastSize = fi.ModTime(), fi.Size()
+	}
+
+	for range time.Tick(interval) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			options.log.Warn("cannot stat zk hosts file", "path", path, "error", err)
+			continue
+		}
+		if fi.ModTime().Equal(lastMod) && fi.Size() == lastSize {
+			continue
+		}
+
+		hosts, err := readHostsFile(path)
+		if err == nil {
+			var tlsConfigs map[string]*tls.Config
+			var adminHosts map[string]*tls.Config
+			if hosts, tlsConfigs, adminHosts, err = splitHostSchemes(hosts, options.SchemeTLS); err == nil {
+				options.SetHostTLSConfigs(tlsConfigs)
+				options.SetAdminHosts(adminHosts)
+			}
+		}
+		if err != nil {
+			options.log.Warn("cannot reload zk hosts file, keeping previous hosts", "path", path, "error", err)
+			continue
+		}
+		lastMod, lastSize = fi.ModTime(), fi.Size()
+
+		hosts = normalizeHosts(hosts, options.log)
+		options.SetHosts(hosts)
+		options.log.Info("zookeeper hosts reloaded", "path", path, "hosts", strings.Join(hosts, ","))
+	}
+}
@@ -0,0 +1,154 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host, want string
+		err        bool
+	}{
+		{host: "10.0.0.1:2181", want: "10.0.0.1:2181"},
+		{host: "10.0.0.1", want: "10.0.0.1:2181"},
+		{host: " 10.0.0.1:2182 ", want: "10.0.0.1:2182"},
+		{host: "[2001:db8::1]:2181", want: "[2001:db8::1]:2181"},
+		{host: "[2001:db8::1]", want: "[2001:db8::1]:2181"},
+		{host: "2001:db8::1", want: "[2001:db8::1]:2181"},
+		{host: "[2001:0db8:0:0:0:0:0:1]:2182", want: "[2001:db8::1]:2182"},
+		{host: "zk-0.zk-hs:2181", want: "zk-0.zk-hs:2181"},
+		{host: "zk-0.zk-hs", want: "zk-0.zk-hs:2181"},
+		{host: "zk-0:", want: "zk-0:2181"},
+		{host: "unix:///var/run/zk.sock", want: "unix:///var/run/zk.sock"},
+		{host: ":2181", err: true},
+		{host: "unix://", err: true},
+		{host: "zk-0:2181:2181", err: true},
+		{host: "10.0.0.1:0", err: true},
+		{host: "10.0.0.1:65536", err: true},
+		{host: "[2001:db8::1]:99999", err: true},
+		{host: "zk-0:abc", err: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeHost(tt.host)
+		if tt.err {
+			if err == nil {
+				t.Errorf("normalizeHost(%q) = %q, expected error", tt.host, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeHost(%q) = %q, %v, want %q", tt.host, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHosts(t *testing.T) {
+	tests := []struct {
+		flag string
+		want []string
+	}{
+		{"", []string{}},
+		{",", []string{}},
+		{"10.0.0.1:2181", []string{"10.0.0.1:2181"}},
+		{" 10.0.0.1 , 10.0.0.2:2182,,", []string{"10.0.0.1:2181", "10.0.0.2:2182"}},
+		{"zk-0,[2001:db8::1],unix:///run/zk.sock", []string{"zk-0:2181", "[2001:db8::1]:2181", "unix:///run/zk.sock"}},
+		// entries with invalid port are dropped
+		{"10.0.0.1:2181,10.0.0.2:99999,zk-0:abc", []string{"10.0.0.1:2181"}},
+	}
+	for _, tt := range tests {
+		got := normalizeHosts(strings.Split(tt.flag, ","), newTestOptions().log)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeHosts(%q) = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+}
+
+func TestReadHostsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zk-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	data := "# ensemble\n10.0.0.1:2181\n\n  10.0.0.2  \n# 10.0.0.3:2181\ntls://10.0.0.4:2281\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := readHostsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.1:2181", "10.0.0.2", "tls://10.0.0.4:2281"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("readHostsFile = %q, want %q", hosts, want)
+	}
+}
+
+func TestNormalizeHostsDeduplicates(t *testing.T) {
+	hosts := []string{
+		"10.0.0.1:2181", "10.0.0.1", " 10.0.0.1:2181 ",
+		"ZK-0.example.com:2181", "zk-0.example.com",
+		"[2001:db8::1]:2181", "[2001:0db8::1]",
+		"unix:///run/zk.sock", "unix:///run/ZK.sock",
+		"10.0.0.2:2181",
+	}
+	want := []string{"10.0.0.1:2181", "ZK-0.example.com:2181", "[2001:db8::1]:2181", "unix:///run/zk.sock", "unix:///run/ZK.sock", "10.0.0.2:2181"}
+	if got := normalizeHosts(hosts, newTestOptions().log); !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeHosts(%q) = %q, want %q", hosts, got, want)
+	}
+}
+
+func TestSplitHostSchemes(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	hosts, tlsConfigs, adminHosts, err := splitHostSchemes([]string{
+		"tcp://10.0.0.1:2181", "tls://10.0.0.2:2281", "http://10.0.0.3:8080", "https://10.0.0.4:8443", "10.0.0.5:2181",
+	}, tlsConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.1:2181", "10.0.0.2:2281", "10.0.0.3:8080", "10.0.0.4:8443", "10.0.0.5:2181"}
+	if len(hosts) != len(want) {
+		t.Fatalf("hosts = %v, want %v", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("hosts[%d] = %q, want %q", i, hosts[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		host        string
+		tls, admin  bool
+		tlsConfig   *tls.Config
+		adminConfig *tls.Config
+	}{
+		{"10.0.0.1:2181", true, false, nil, nil},
+		{"10.0.0.2:2281", true, false, tlsConfig, nil},
+		{"10.0.0.3:8080", false, true, nil, nil},
+		{"10.0.0.4:8443", false, true, nil, tlsConfig},
+		{"10.0.0.5:2181", false, false, nil, nil},
+	}
+	for _, tt := range tests {
+		config, ok := tlsConfigs[tt.host]
+		if ok != tt.tls || config != tt.tlsConfig {
+			t.Errorf("%s: tls config %p (set: %v), want %p (set: %v)", tt.host, config, ok, tt.tlsConfig, tt.tls)
+		}
+		config, ok = adminHosts[tt.host]
+		if ok != tt.admin || config != tt.adminConfig {
+			t.Errorf("%s: admin tls config %p (admin: %v), want %p (admin: %v)", tt.host, config, ok, tt.adminConfig, tt.admin)
+		}
+	}
+
+	for _, h := range []string{"http://10.0.0.3", "https://10.0.0.3", "ftp://10.0.0.3:21"} {
+		if _, _, _, err := splitHostSchemes([]string{h}, nil); err == nil {
+			t.Errorf("%s: expected error", h)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// location of service account credentials mounted into pods
+const k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// k8sEndpoints is a kubernetes Endpoints object, only fields needed
+// to build list of ready pods are decoded
+type k8sEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP        string
+			TargetRef *struct {
+				Kind string
+				Name string
+			}
+		}
+		Ports []struct {
+			Name string
+			Port int
+		}
+	}
+}
+
+// k8sClient queries kubernetes api from within cluster using pod's service account
+type k8sClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newK8sClient(timeout time.Duration) (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT aren't set")
+	}
+
+	token, err := ioutil.ReadFile(k8sServiceAccountDir + "token")
+	if err != nil {
+		return nil, fmt.Errorf("can't read service account token: %v", err)
+	}
+	pem, err := ioutil.ReadFile(k8sServiceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("can't read service account ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("can't find any certificates in service account ca")
+	}
+
+	return &k8sClient{
+		baseURL: "https://" + net.JoinHostPort(host, port),
+		token:   strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// namespace of exporter's pod, used if -k8s-namespace isn't set
+func k8sPodNamespace() (string, error) {
+	ns, err := ioutil.ReadFile(k8sServiceAccountDir + "namespace")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// list ready endpoints of service, returns 'ip:port' list and pod names keyed
+// by 'ip:port'; port is chosen by name, or the first one if name is empty
+func (c *k8sClient) endpoints(namespace, service, portName string) ([]string, map[string]string, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/endpoints/%s", c.baseURL, url.PathEscape(namespace), url.PathEscape(service))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var ep k8sEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&ep); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode response: %s", err)
+	}
+
+	var hosts []string
+	pods := map[string]string{}
+	for _, subset := range ep.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if portName == "" || p.Name == portName {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, addr := range subset.Addresses {
+			h := net.JoinHostPort(addr.IP, strconv.Itoa(port))
+			hosts = append(hosts, h)
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				pods[h] = addr.TargetRef.Name
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("no ready endpoints of service %s/%s", namespace, service)
+	}
+
+	sort.Strings(hosts)
+	return hosts, pods, nil
+}
+
+// periodically list endpoints of service and update hosts and their pod name
+// aliases once set of ready endpoints is changed; previous hosts are kept on errors
+func watchK8sEndpoints(options *Options, client *k8sClient, namespace, service, portName string, interval time.Duration) {
+	last := strings.Join(options.GetHosts(), ",")
+
+	for range time.Tick(interval) {
+		hosts, pods, err := client.endpoints(namespace, service, portName)
+		if err != nil {
+			options.log.Warn("cannot list endpoints, keeping previous hosts", "namespace", namespace, "service", service, "error", err)
+			continue
+		}
+
+		hosts = normalizeHosts(hosts, options.log)
+		if joined := strings.Join(hosts, ","); joined != last {
+			last = joined
+			options.SetHostAliases(pods)
+			options.SetHosts(hosts)
+			options.log.Info("zookeeper hosts discovered", "namespace", namespace, "service", service, "hosts", joined)
+		}
+	}
+}
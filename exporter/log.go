@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelFatal
+)
+
+// level names used in -log-level flag and json output
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+	levelFatal: "fatal",
+}
+
+// level prefixes used in text output
+var logLevelPrefixes = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warning",
+	levelError: "error",
+	levelFatal: "fatal",
+}
+
+// leveledLogger writes messages with key-value fields either as text or json lines
+type leveledLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level logLevel
+	json  bool
+}
+
+// configure logger from -log-level and -log-format flag values
+func (l *leveledLogger) configure(level, format string) error {
+	found := false
+	for lvl, name := range logLevelNames {
+		if name == level && lvl != levelFatal {
+			l.level, found = lvl, true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+
+	switch format {
+	case "text":
+		l.json = false
+	case "json":
+		l.json = true
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+func (l *leveledLogger) Debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *leveledLogger) Info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *leveledLogger) Warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *leveledLogger) Error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// Fatal logs message and exits
+func (l *leveledLogger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelFatal, msg, kv)
+	os.Exit(1)
+}
+
+// log message with key-value pairs of fields, e.g. log(levelWarn, "msg", []interface{}{"zk_host", h})
+func (l *leveledLogger) log(level logLevel, msg string, kv []interface{}) {
+	if level < l.level {
+		return
+	}
+
+	var buf bytes.Buffer
+	now := time.Now()
+	if l.json {
+		fmt.Fprintf(&buf, `{"time":%s,"level":%s,"msg":%s`,
+			jsonString(now.Format(time.RFC3339)), jsonString(logLevelNames[level]), jsonString(msg))
+		for i := 0; i < len(kv); i += 2 {
+			fmt.Fprintf(&buf, ",%s:%s", jsonString(fmt.Sprint(kv[i])), jsonString(fieldValue(kv, i+1)))
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %s: %s", now.Format("2006/01/02 15:04:05"), logLevelPrefixes[level], msg)
+		for i := 0; i < len(kv); i += 2 {
+			v := fieldValue(kv, i+1)
+			if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&buf, " %v=%s", kv[i], v)
+		}
+		buf.WriteString("\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// return value of i-th field as a string
+func fieldValue(kv []interface{}, i int) string {
+	if i >= len(kv) {
+		return ""
+	}
+	return fmt.Sprint(kv[i])
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// newLogger returns logger which writes to out, stdout if nil; level and format
+// are the same as -log-level and -log-format flags, 'info' and 'text' if empty
+func newLogger(out io.Writer, level, format string) (*leveledLogger, error) {
+	l := &leveledLogger{out: out}
+	if l.out == nil {
+		l.out = os.Stdout
+	}
+	if level == "" {
+		level = "info"
+	}
+	if format == "" {
+		format = "text"
+	}
+	if err := l.configure(level, format); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
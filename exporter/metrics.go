@@ -0,0 +1,658 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	invalidMetricCharsRE = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	invalidLabelCharsRE  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelValueReplacer   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// content type of prometheus text format, which is requested from zk metrics provider
+const contentTypeText = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricInfo describes metric family type and help text
+type metricInfo struct {
+	typ  string
+	help string
+}
+
+// known metric families, both synthesized by exporter and reported by 'mntr';
+// families which aren't listed here are exposed as untyped
+var knownMetrics = map[string]metricInfo{
+	"zk_up":                  {"gauge", "Whether connection to zookeeper server was established."},
+	"zk_mntr_scrape_success": {"gauge", "Whether 'mntr' returned parseable data."},
+	"zk_connection_error":    {"gauge", "Reason why zookeeper server isn't up or 'mntr' failed: resolve, connect, timeout, not_whitelisted, auth, proxy, breaker_open or error, as a label."},
+	"zk_ruok":                {"gauge", "Whether zookeeper server responded 'imok' to 'ruok' command."},
+	"zk_ruok_error":          {"gauge", "Reason why 'ruok' failed: connect, timeout, not_whitelisted, not_imok or error, as a label."},
+	"zk_server_leader":       {"gauge", "Whether zookeeper server is a leader of the ensemble."},
+	"zk_version":             {"gauge", "Zookeeper server version, as a label."},
+	"zk_server_role":         {"gauge", "Zookeeper server role: leader, follower, observer or standalone, as a label."},
+	"zk_server_state":        {"gauge", "Zookeeper server state: leader, follower, observer, standalone or read-only, as a label."},
+	"zk_peer_state":          {"gauge", "Zookeeper quorum peer state, as a label."},
+	"zk_server_mode":         {"gauge", "Zookeeper server mode reported by 'srvr', as a label."},
+	"zk_read_only":           {"gauge", "Whether zookeeper server is in read-only mode, reported by 'isro'."},
+	"zk_not_serving":         {"gauge", "Set for zookeeper servers in leader only state, which don't serve client requests."},
+
+	"zk_last_successful_scrape_timestamp_seconds": {"gauge", "Unix time of the last scrape when 'mntr' of zookeeper server succeeded."},
+
+	"zk_last_zxid":          {"gauge", "Last processed zxid, reported by 'srvr' and 'stat'."},
+	"zk_current_epoch":      {"gauge", "Current epoch, high 32 bits of zxid, which grows with every leader election; reported by 'srvr' and 'stat'."},
+	"zk_datadir_size_bytes": {"gauge", "Size of zookeeper data directory with snapshots, in bytes, reported by 'dirs'."},
+	"zk_logdir_size_bytes":  {"gauge", "Size of zookeeper transaction log directory, in bytes, reported by 'dirs'."},
+	"zk_watch_connections":  {"gauge", "Number of connections with watches, reported by 'wchs'."},
+	"zk_watch_paths":        {"gauge", "Number of watched paths, reported by 'wchs'."},
+	"zk_conf_version":       {"gauge", "Version of dynamic config, reported as hex by 'conf'."},
+	"zk_conf_quorum_size":   {"gauge", "Number of voting members of the ensemble, reported by 'conf'."},
+
+	"zk_cons_connections":      {"gauge", "Number of client connections, reported by 'cons'."},
+	"zk_cons_queued":           {"gauge", "Number of requests queued on client connections, reported by 'cons'."},
+	"zk_cons_packets_received": {"gauge", "Number of packets received on open client connections, reported by 'cons'."},
+	"zk_cons_packets_sent":     {"gauge", "Number of packets sent on open client connections, reported by 'cons'."},
+	"zk_cons_max_latency":      {"gauge", "Highest max latency of open client connections, in milliseconds, reported by 'cons'."},
+
+	"zk_jvm_heap_used_bytes":             {"gauge", "Used jvm heap memory, in bytes, reported by 'mntr' of some distributions."},
+	"zk_jvm_heap_committed_bytes":        {"gauge", "Committed jvm heap memory, in bytes, reported by 'mntr' of some distributions."},
+	"zk_jvm_heap_max_bytes":              {"gauge", "Max jvm heap memory, in bytes, reported by 'mntr' of some distributions."},
+	"zk_jvm_nonheap_used_bytes":          {"gauge", "Used jvm non-heap memory, in bytes, reported by 'mntr' of some distributions."},
+	"zk_jvm_threads":                     {"gauge", "Number of live jvm threads, reported by 'mntr' of some distributions."},
+	"zk_jvm_threads_daemon":              {"gauge", "Number of live jvm daemon threads, reported by 'mntr' of some distributions."},
+	"zk_jvm_gc_collections_total":        {"counter", "Number of jvm garbage collections, reported by 'mntr' of some distributions."},
+	"zk_jvm_gc_collection_seconds_total": {"counter", "Time spent in jvm garbage collections, in seconds, reported by 'mntr' of some distributions."},
+	"zk_process_open_fds":                {"gauge", "Number of open file descriptors of zookeeper process, reported by 'mntr' of some distributions."},
+	"zk_process_max_fds":                 {"gauge", "Max number of open file descriptors of zookeeper process, reported by 'mntr' of some distributions."},
+	"zk_process_cpu_seconds_total":       {"counter", "CPU time of zookeeper process, in seconds, reported by 'mntr' of some distributions."},
+	"zk_process_resident_memory_bytes":   {"gauge", "Resident memory of zookeeper process, in bytes, reported by 'mntr' of some distributions."},
+	"zk_process_start_time_seconds":      {"gauge", "Start time of zookeeper process, in seconds since epoch, reported by 'mntr' of some distributions."},
+
+	"zk_ensemble_leaders_total":   {"gauge", "Number of scraped zookeeper servers which are leaders, more than one means split-brain."},
+	"zk_ensemble_followers_total": {"gauge", "Number of scraped zookeeper servers which are followers."},
+	"zk_ensemble_leader":          {"gauge", "Set for every scraped zookeeper server which is a leader, more than one means split-brain."},
+
+	"zk_exporter_up":                            {"gauge", "Whether exporter is running, always 1."},
+	"zk_probe_success":                          {"gauge", "Whether zookeeper server passed to probe location is up."},
+	"zk_exporter_build_info":                    {"gauge", "Exporter build information, as labels."},
+	"zk_exporter_hosts_total":                   {"gauge", "Number of configured zookeeper servers."},
+	"zk_exporter_hosts_up":                      {"gauge", "Number of zookeeper servers which are up."},
+	"zk_exporter_last_scrape_timestamp_seconds": {"gauge", "Unix time of the last scrape of zookeeper servers."},
+	"zk_exporter_scrape_panics_total":           {"counter", "Number of panics recovered while scraping zookeeper servers or serving metrics."},
+	"zk_exporter_scrape_errors_total":           {"counter", "Number of failed zookeeper commands: connection, send, read and parse errors, and commands which aren't whitelisted."},
+	"zk_exporter_resolve_failures_total":        {"counter", "Number of times zookeeper hostname couldn't be resolved."},
+	"zk_exporter_not_whitelisted_total":         {"counter", "Number of times zookeeper command wasn't executed because it isn't in 4lw.commands.whitelist."},
+	"zk_exporter_nondigit_skipped_total":        {"counter", "Number of 'mntr' values which were skipped because they aren't numbers."},
+	"zk_exporter_retries":                       {"gauge", "Number of connection retries used during the last scrape of zookeeper server."},
+	"zk_exporter_scrape_duration_seconds":       {"gauge", "Duration of the last scrape, per zookeeper server and in total."},
+	"zk_exporter_scrape_inflight":               {"gauge", "Peak number of zookeeper servers scraped at once during the last scrape."},
+	"zk_exporter_scrape_queue_wait_seconds":     {"gauge", "Time zookeeper server waited for a -max-concurrency slot during the last scrape."},
+	"zk_exporter_command_duration_seconds":      {"histogram", "Duration of successful 4lw commands, including connection and retries."},
+	"zk_exporter_goroutines":                    {"gauge", "Number of goroutines of exporter after the last scrape."},
+	"zk_exporter_open_fds":                      {"gauge", "Number of open file descriptors of exporter after the last scrape, linux only."},
+	"zk_exporter_breaker_open":                  {"gauge", "Whether circuit breaker of zookeeper server is open, i.e. server is reported down without connecting to it."},
+
+	"zk_exporter_client_cert_expiry_timestamp_seconds": {"gauge", "Unix time when zookeeper tls client certificate chain expires."},
+
+	"zk_avg_latency":                  {"gauge", "Deprecated, use zk_avg_latency_seconds. Average latency of client requests, in milliseconds."},
+	"zk_min_latency":                  {"gauge", "Deprecated, use zk_min_latency_seconds. Minimal latency of client requests, in milliseconds."},
+	"zk_max_latency":                  {"gauge", "Deprecated, use zk_max_latency_seconds. Maximal latency of client requests, in milliseconds."},
+	"zk_packets_received":             {"counter", "Number of packets received from clients."},
+	"zk_packets_sent":                 {"counter", "Number of packets sent to clients."},
+	"zk_num_alive_connections":        {"gauge", "Number of active client connections."},
+	"zk_outstanding_requests":         {"gauge", "Number of queued client requests."},
+	"zk_znode_count":                  {"gauge", "Number of znodes."},
+	"zk_watch_count":                  {"gauge", "Number of watches."},
+	"zk_ephemerals_count":             {"gauge", "Number of ephemeral znodes."},
+	"zk_approximate_data_size":        {"gauge", "Approximate size of data, in bytes."},
+	"zk_open_file_descriptor_count":   {"gauge", "Number of open file descriptors."},
+	"zk_max_file_descriptor_count":    {"gauge", "Maximal number of file descriptors."},
+	"zk_followers":                    {"gauge", "Number of followers, reported by leader only."},
+	"zk_synced_followers":             {"gauge", "Number of synced followers, reported by leader only."},
+	"zk_pending_syncs":                {"gauge", "Number of pending syncs, reported by leader only."},
+	"zk_last_proposal_size":           {"gauge", "Size of the last proposal, in bytes."},
+	"zk_min_proposal_size":            {"gauge", "Minimal size of proposals, in bytes."},
+	"zk_max_proposal_size":            {"gauge", "Maximal size of proposals, in bytes."},
+	"zk_fsync_threshold_exceed_count": {"counter", "Number of times fsync duration exceeded the warning threshold."},
+	"zk_uptime":                       {"gauge", "Deprecated, use zk_uptime_seconds. Zookeeper server uptime, in milliseconds."},
+	"zk_uptime_seconds":               {"gauge", "Zookeeper server uptime, in seconds."},
+	"zk_quorum_size":                  {"gauge", "Number of voting members of the ensemble."},
+	"zk_global_sessions":              {"gauge", "Number of global sessions."},
+	"zk_local_sessions":               {"gauge", "Number of local sessions."},
+	"zk_connection_drop_count":        {"counter", "Number of dropped client connections."},
+
+	"zk_learners":                        {"gauge", "Number of learners, i.e. followers and observers, reported by leader only since zk 3.6."},
+	"zk_synced_non_voting_followers":     {"gauge", "Number of synced non-voting followers, reported by leader only since zk 3.6."},
+	"zk_synced_observers":                {"gauge", "Number of synced observers, reported by leader only since zk 3.6."},
+	"zk_proposal_count":                  {"counter", "Number of proposals sent to followers, reported by leader only since zk 3.6."},
+	"zk_commit_count":                    {"counter", "Number of commits performed on leader, since zk 3.6."},
+	"zk_learner_proposal_received_count": {"counter", "Number of proposals received from leader, reported by followers and observers since zk 3.6."},
+	"zk_learner_commit_received_count":   {"counter", "Number of commits received from leader, reported by followers and observers since zk 3.6."},
+}
+
+// lookupMetricInfo returns type and help for metric family, falling back to untyped
+func lookupMetricInfo(name string) metricInfo {
+	if info, ok := knownMetrics[name]; ok {
+		return info
+	}
+	return metricInfo{"untyped", fmt.Sprintf("Zookeeper metric %s.", name)}
+}
+
+// label is a single name-value pair attached to a series
+type label struct {
+	name  string
+	value string
+}
+
+// series is a single sample of a metric family
+type series struct {
+	name   string
+	labels []label
+	value  string
+
+	// name of metric family, differs from name for '_sum' and '_count'
+	// series of summaries
+	family string
+}
+
+// metricSet holds series gathered during a scrape, keyed by name and labels,
+// so that the same series can't be emitted twice; safe for concurrent use
+type metricSet struct {
+	mu     sync.Mutex
+	series map[string]series
+	// type and help of families which aren't known, but were discovered
+	// during scrape, e.g. of zk metrics provider endpoint
+	info map[string]metricInfo
+
+	// scrape panicked, so that metric set is incomplete
+	panicked bool
+
+	// when scrape started, rendered as timestamp of samples if enabled
+	timestamp time.Time
+
+	// -metric-allow and -metric-deny, checked before series reported by
+	// zk servers are added, so that series of denied families aren't stored,
+	// and for the rest of series, e.g. of exporter itself, when they're collected
+	filter *MetricFilter
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{series: map[string]series{}, info: map[string]metricInfo{}, timestamp: time.Now()}
+}
+
+// setInfo sets type and help of metric family, unless it's known; it has to be
+// called before series of summaries are added, to group them by family
+func (m *metricSet) setInfo(name string, info metricInfo) {
+	name = sanitizeMetricName(name)
+	if _, ok := knownMetrics[name]; ok {
+		return
+	}
+	m.mu.Lock()
+	m.info[name] = info
+	m.mu.Unlock()
+}
+
+// lookupInfo returns type and help for metric family, falling back to untyped
+func (m *metricSet) lookupInfo(name string) metricInfo {
+	m.mu.Lock()
+	info, ok := m.info[name]
+	m.mu.Unlock()
+	if ok {
+		return info
+	}
+	return lookupMetricInfo(name)
+}
+
+// MetricFilter drops metric families by name, deny takes precedence over allow;
+// nil filter and nil regexps allow everything
+type MetricFilter struct {
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+}
+
+// NewMetricFilter compiles allow and deny expressions of Options.MetricFilter, the
+// same as -metric-allow and -metric-deny flags; they have to match whole metric name,
+// empty expression allows everything and denies nothing
+func NewMetricFilter(allow, deny string) (*MetricFilter, error) {
+	filter := &MetricFilter{}
+	var err error
+	if allow != "" {
+		if filter.allow, err = regexp.Compile("^(?:" + allow + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid allow expression: %v", err)
+		}
+	}
+	if deny != "" {
+		if filter.deny, err = regexp.Compile("^(?:" + deny + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid deny expression: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+func (f *MetricFilter) allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.deny != nil && f.deny.MatchString(name) {
+		return false
+	}
+	return f.allow == nil || f.allow.MatchString(name)
+}
+
+// allowed reports whether metric family of series with given name passes filter
+func (m *metricSet) allowed(name string) bool {
+	m.mu.Lock()
+	family := m.familyOf(sanitizeMetricName(name))
+	m.mu.Unlock()
+	return m.filter.allowed(family)
+}
+
+// add stores a series, replacing previously stored one with the same name and labels
+func (m *metricSet) add(name string, labels []label, value string) {
+	s := newSeries(name, labels)
+	s.value = value
+
+	m.mu.Lock()
+	s.family = m.familyOf(s.name)
+	m.series[s.id()] = s
+	m.mu.Unlock()
+}
+
+// addInfo stores info series with value 1, e.g. 'zk_server_state{state="leader"}', replacing
+// series of the same name and labels with other value of info label, so that only one
+// of them is exported, e.g. when 'mntr' and 'srvr' of zk server report different states
+func (m *metricSet) addInfo(name string, labels []label, info label) {
+	s := newSeries(name, append(labels[:len(labels):len(labels)], info))
+	s.value = "1"
+	base := newSeries(name, labels).id()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, old := range m.series {
+		if old.name == s.name && old.withoutLabels(info.name).id() == base {
+			delete(m.series, id)
+		}
+	}
+	s.family = m.familyOf(s.name)
+	m.series[s.id()] = s
+}
+
+// value returns value of series with given name and labels, false if it wasn't added
+func (m *metricSet) value(name string, labels []label) (string, bool) {
+	id := newSeries(name, labels).id()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[id]
+	return s.value, ok
+}
+
+// newSeries returns series with sanitized name and labels, sorted by name
+func newSeries(name string, labels []label) series {
+	s := series{
+		name:   sanitizeMetricName(name),
+		labels: make([]label, 0, len(labels)),
+	}
+	for _, l := range labels {
+		s.labels = append(s.labels, label{name: sanitizeLabelName(l.name), value: l.value})
+	}
+	sort.SliceStable(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+	return s
+}
+
+// setPanicked marks metric set as incomplete
+func (m *metricSet) setPanicked() {
+	m.mu.Lock()
+	m.panicked = true
+	m.mu.Unlock()
+}
+
+// isPanicked reports whether scrape panicked, i.e. metric set is incomplete
+func (m *metricSet) isPanicked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.panicked
+}
+
+// familyOf returns name of family which series belongs to: summary name for its
+// '_sum' and '_count' series, or series name itself for other metrics; m.mu must
+// be held
+func (m *metricSet) familyOf(name string) string {
+	for _, suffix := range []string{"_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if base == name {
+			continue
+		}
+		info, ok := knownMetrics[base]
+		if !ok {
+			info = m.info[base]
+		}
+		if info.typ == "summary" {
+			return base
+		}
+	}
+	return name
+}
+
+// count returns number of series with given name and value
+func (m *metricSet) count(name, value string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, s := range m.series {
+		if s.name == name && s.value == value {
+			n++
+		}
+	}
+	return n
+}
+
+// exposition describes how metrics are rendered: prefix is prepended to every
+// metric name, and samples get scrape timestamp if timestamps are enabled; in
+// OpenMetrics format counters without '_total' suffix get it, see gatherer
+type exposition struct {
+	prefix      string
+	timestamps  bool
+	openMetrics bool
+}
+
+// gatherer returns metrics of the set, along with exporter metrics of state unless
+// it's nil, rendered according to e; series are rebuilt by every scrape, so each
+// response gets its own registry and series of previous scrapes, e.g. of hosts
+// which are gone, aren't kept
+func (m *metricSet) gatherer(e exposition, state *exporterState) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	collectors := []prometheus.Collector{m}
+	if state != nil {
+		collectors = append(collectors, state)
+	}
+	prometheus.WrapRegistererWithPrefix(e.prefix, registry).MustRegister(collectors...)
+
+	timestamp := m.timestamp.UnixNano() / int64(time.Millisecond)
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := registry.Gather()
+		for _, f := range families {
+			// OpenMetrics declares counter without '_total' suffix as unknown, so counters
+			// reported by zk without it, e.g. zk_packets_received, get it only in this
+			// format: family keeps the original name and samples get the suffix
+			if e.openMetrics && f.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(f.GetName(), "_total") {
+				name := f.GetName() + "_total"
+				f.Name = &name
+			}
+			if e.timestamps {
+				for _, metric := range f.Metric {
+					metric.TimestampMs = &timestamp
+				}
+			}
+		}
+		return families, err
+	})
+}
+
+// Describe sends nothing: series of metric set are known only after scrape,
+// so it's an unchecked collector
+func (m *metricSet) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends all series as constant metrics; series of summaries are assembled
+// from their quantile, '_sum' and '_count' series
+func (m *metricSet) Collect(ch chan<- prometheus.Metric) {
+	type summary struct {
+		family    string
+		labels    []label
+		quantiles map[float64]float64
+		count     uint64
+		sum       float64
+	}
+	summaries := map[string]*summary{}
+	var order []string
+
+	for _, s := range m.sorted() {
+		// filter matches name as reported, counter which gets '_total' suffix
+		// in OpenMetrics format is allowed or denied by its original name
+		if !m.filter.allowed(s.family) {
+			continue
+		}
+		info := m.lookupInfo(s.family)
+		value, err := strconv.ParseFloat(s.value, 64)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(prometheus.NewDesc(s.name, info.help, nil, nil),
+				fmt.Errorf("invalid value %q of %s: %v", s.value, s.id(), err))
+			continue
+		}
+
+		if info.typ != "summary" {
+			desc := prometheus.NewDesc(s.name, info.help, nil, s.promLabels())
+			metric, err := prometheus.NewConstMetric(desc, valueType(info.typ), value)
+			if err != nil {
+				metric = prometheus.NewInvalidMetric(desc, err)
+			}
+			ch <- metric
+			continue
+		}
+
+		labels := s.withoutLabels("quantile")
+		id := s.family + labels.labelsID()
+		d, ok := summaries[id]
+		if !ok {
+			d = &summary{family: s.family, labels: labels.labels, quantiles: map[float64]float64{}}
+			summaries[id] = d
+			order = append(order, id)
+		}
+		switch s.name {
+		case s.family + "_sum":
+			d.sum = value
+		case s.family + "_count":
+			d.count = uint64(value)
+		default:
+			if q, err := strconv.ParseFloat(labelValue(s.labels, "quantile"), 64); err == nil {
+				d.quantiles[q] = value
+			}
+		}
+	}
+
+	for _, id := range order {
+		d := summaries[id]
+		desc := prometheus.NewDesc(d.family, m.lookupInfo(d.family).help, nil, series{labels: d.labels}.promLabels())
+		metric, err := prometheus.NewConstSummary(desc, d.count, d.sum, d.quantiles)
+		if err != nil {
+			metric = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- metric
+	}
+}
+
+// sorted returns series ordered by metric name and labels
+func (m *metricSet) sorted() []series {
+	m.mu.Lock()
+	all := make([]series, 0, len(m.series))
+	for _, s := range m.series {
+		all = append(all, s)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].id() < all[j].id() })
+	return all
+}
+
+// withoutLabels returns copy of series without given labels
+func (s series) withoutLabels(names ...string) series {
+	labels := make([]label, 0, len(s.labels))
+	for _, l := range s.labels {
+		if !containsString(names, l.name) {
+			labels = append(labels, l)
+		}
+	}
+	s.labels = labels
+	return s
+}
+
+// id returns series name with rendered labels, e.g. 'zk_up{zk_host="10.0.0.1:2181"}'
+func (s series) id() string {
+	if len(s.labels) == 0 {
+		return s.name
+	}
+	pairs := make([]string, 0, len(s.labels))
+	for _, l := range s.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l.name, escapeLabelValue(l.value)))
+	}
+	return fmt.Sprintf("%s{%s}", s.name, strings.Join(pairs, ","))
+}
+
+// hostKey identifies zk server which series with given labels belongs to, the same
+// as key of circuit breaker: id of its 'cluster' and 'zk_host' labels; empty for
+// series of exporter itself
+func hostKey(labels []label) string {
+	var host []label
+	for _, l := range labels {
+		if l.name == "cluster" || l.name == "zk_host" {
+			host = append(host, l)
+		}
+	}
+	if len(host) == 0 {
+		return ""
+	}
+	sort.SliceStable(host, func(i, j int) bool { return host[i].name < host[j].name })
+	return series{labels: host}.labelsID()
+}
+
+// hostsOf returns 'cluster' and 'zk_host' labels of zk servers which have metrics
+// in collector, keyed by host key
+func hostsOf(c prometheus.Collector) map[string][]label {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	hosts := map[string][]label{}
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		var labels []label
+		for _, pair := range m.Label {
+			if pair.GetName() == "cluster" || pair.GetName() == "zk_host" {
+				labels = append(labels, label{pair.GetName(), pair.GetValue()})
+			}
+		}
+		if key := hostKey(labels); key != "" {
+			hosts[key] = labels
+		}
+	}
+	return hosts
+}
+
+// promLabels returns labels of series as prometheus const labels
+func (s series) promLabels() prometheus.Labels {
+	labels := make(prometheus.Labels, len(s.labels))
+	for _, l := range s.labels {
+		labels[l.name] = l.value
+	}
+	return labels
+}
+
+// type of sample value of metric family, families of other types are untyped
+func valueType(typ string) prometheus.ValueType {
+	switch typ {
+	case "counter":
+		return prometheus.CounterValue
+	case "gauge":
+		return prometheus.GaugeValue
+	}
+	return prometheus.UntypedValue
+}
+
+// labelsID returns rendered labels of series, i.e. its id without name
+func (s series) labelsID() string {
+	return strings.TrimPrefix(s.id(), s.name)
+}
+
+// return value of label with given name, or empty string
+func labelValue(labels []label, name string) string {
+	for _, l := range labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+func sanitizeMetricName(name string) string {
+	name = invalidMetricCharsRE.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func sanitizeLabelName(name string) string {
+	name = invalidLabelCharsRE.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// escape label value according to prometheus text format
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+// split mntr key like 'zk_write_per_namespace{key="solrcloud7",quantile="0.5"}'
+// into metric name and labels, label values may contain escaped quotes and commas
+func parseMetricKey(key string) (string, []label, error) {
+	i := strings.Index(key, "{")
+	if i < 0 {
+		return key, nil, nil
+	}
+	if !strings.HasSuffix(key, "}") {
+		return "", nil, fmt.Errorf("unterminated label set in %q", key)
+	}
+
+	name := key[:i]
+	block := key[i+1 : len(key)-1]
+
+	var labels []label
+	for len(block) > 0 {
+		eq := strings.Index(block, "=")
+		if eq < 0 || len(block) < eq+2 || block[eq+1] != '"' {
+			return "", nil, fmt.Errorf("malformed label set in %q", key)
+		}
+		labelName := strings.TrimSpace(block[:eq])
+
+		// scan quoted value until unescaped quote
+		var value strings.Builder
+		j := eq + 2
+		for ; j < len(block) && block[j] != '"'; j++ {
+			if block[j] == '\\' && j+1 < len(block) {
+				j++
+				switch block[j] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(block[j])
+				}
+				continue
+			}
+			value.WriteByte(block[j])
+		}
+		if j >= len(block) {
+			return "", nil, fmt.Errorf("unterminated label value in %q", key)
+		}
+
+		labels = append(labels, label{name: labelName, value: value.String()})
+		block = strings.TrimLeft(strings.TrimSpace(block[j+1:]), ",")
+	}
+
+	return name, labels, nil
+}
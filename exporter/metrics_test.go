@@ -0,0 +1,247 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestParseMetricKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		name   string
+		labels []label
+		err    bool
+	}{
+		{key: "zk_znode_count", name: "zk_znode_count"},
+		{key: "zk_foo.bar", name: "zk_foo.bar"},
+		{key: "zk-foo", name: "zk-foo"},
+		{
+			key:    `zk_write_per_namespace{key="solrcloud7",quantile="0.5"}`,
+			name:   "zk_write_per_namespace",
+			labels: []label{{"key", "solrcloud7"}, {"quantile", "0.5"}},
+		},
+		{
+			key:    `zk_write_per_namespace{key="a,b", quantile="0.99"}`,
+			name:   "zk_write_per_namespace",
+			labels: []label{{"key", "a,b"}, {"quantile", "0.99"}},
+		},
+		{
+			key:    `zk_foo.bar{key="my-ns.v2",quantile="0.5"}`,
+			name:   "zk_foo.bar",
+			labels: []label{{"key", "my-ns.v2"}, {"quantile", "0.5"}},
+		},
+		{
+			key:    `zk-foo{key="a"}`,
+			name:   "zk-foo",
+			labels: []label{{"key", "a"}},
+		},
+		{
+			key:    `zk_write_per_namespace{key="say \"hi\"\n"}`,
+			name:   "zk_write_per_namespace",
+			labels: []label{{"key", "say \"hi\"\n"}},
+		},
+		{key: "zk_write_per_namespace{}", name: "zk_write_per_namespace"},
+		{key: `zk_write_per_namespace{key="a"`, err: true},
+		{key: `zk_write_per_namespace{key="a}`, err: true},
+		{key: `zk_write_per_namespace{key=a}`, err: true},
+	}
+	for _, tt := range tests {
+		name, labels, err := parseMetricKey(tt.key)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseMetricKey(%q): expected error", tt.key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMetricKey(%q): unexpected error: %v", tt.key, err)
+			continue
+		}
+		if name != tt.name || !reflect.DeepEqual(labels, tt.labels) {
+			t.Errorf("parseMetricKey(%q) = %q, %v, want %q, %v", tt.key, name, labels, tt.name, tt.labels)
+		}
+	}
+}
+
+// only metric name of mntr key is sanitized, labels are merged with host labels as is
+func TestAddParsedMetricKey(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		key string
+		id  string
+	}{
+		{"zk_foo.bar", `zk_foo_bar{zk_host="10.0.0.1:2181"}`},
+		{"zk-foo", `zk_foo{zk_host="10.0.0.1:2181"}`},
+		{`zk_foo.bar{key="my-ns.v2",quantile="0.5"}`, `zk_foo_bar{key="my-ns.v2",quantile="0.5",zk_host="10.0.0.1:2181"}`},
+		{`zk-foo{quantile="0.99",key="a,b"}`, `zk_foo{key="a,b",quantile="0.99",zk_host="10.0.0.1:2181"}`},
+	}
+	for _, tt := range tests {
+		name, labels, err := parseMetricKey(tt.key)
+		if err != nil {
+			t.Errorf("parseMetricKey(%q): unexpected error: %v", tt.key, err)
+			continue
+		}
+		metrics := newMetricSet()
+		metrics.add(name, append(labels, hostLabels...), "1")
+		if series := metrics.sorted(); len(series) != 1 || series[0].id() != tt.id {
+			t.Errorf("%q: series %v, want %s", tt.key, series, tt.id)
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"10.0.0.1:2181", "10.0.0.1:2181"},
+		{`my "ns"`, `my \"ns\"`},
+		{`C:\zk\data`, `C:\\zk\\data`},
+		{"line1\nline2", `line1\nline2`},
+		{`\"`, `\\\"`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := escapeLabelValue(tt.value); got != tt.want {
+			t.Errorf("escapeLabelValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// label values which zk servers report, e.g. namespaces of 'mntr' keys, are
+// rendered as valid exposition format whatever characters they contain
+func TestRenderEscapedLabelValues(t *testing.T) {
+	metrics := newMetricSet()
+	metrics.add("zk_write_per_namespace", []label{{"key", "a \"quoted\" \\ ns\nx"}, {"zk_host", "10.0.0.1:2181"}}, "3")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("cannot gather metrics: %v", err)
+	}
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, f := range families {
+		if err := encoder.Encode(f); err != nil {
+			t.Fatalf("cannot encode metrics: %v", err)
+		}
+	}
+
+	want := `zk_write_per_namespace{key="a \"quoted\" \\ ns\nx",zk_host="10.0.0.1:2181"} 3`
+	if !strings.Contains(buf.String(), want+"\n") {
+		t.Errorf("rendered metrics:\n%s\nwant line:\n%s", buf.String(), want)
+	}
+	// rendered metrics parse back to the same label value
+	parsed, err := new(expfmt.TextParser).TextToMetricFamilies(&buf)
+	if err != nil {
+		t.Fatalf("rendered metrics aren't valid: %v", err)
+	}
+	f, ok := parsed["zk_write_per_namespace"]
+	if !ok || len(f.Metric) != 1 {
+		t.Fatalf("zk_write_per_namespace isn't rendered")
+	}
+	for _, l := range f.Metric[0].Label {
+		if l.GetName() == "key" && l.GetValue() != "a \"quoted\" \\ ns\nx" {
+			t.Errorf("parsed key label = %q", l.GetValue())
+		}
+	}
+}
+
+// counters without '_total' suffix get it in OpenMetrics format, so that they're
+// declared as counters rather than unknown; text format keeps their original names
+func TestWriteMetricsCounterTotal(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	metrics := newMetricSet()
+	metrics.add("zk_packets_received", hostLabels, "5")
+	metrics.add("zk_exporter_scrape_errors_total", hostLabels, "2")
+
+	tests := []struct {
+		accept  string
+		want    []string
+		notWant []string
+	}{
+		{
+			accept: "application/openmetrics-text; version=0.0.1",
+			want: []string{
+				"# TYPE zk_packets_received counter\n",
+				`zk_packets_received_total{zk_host="10.0.0.1:2181"} 5.0` + "\n",
+				"# TYPE zk_exporter_scrape_errors counter\n",
+				"# EOF\n",
+			},
+			notWant: []string{"unknown", `zk_packets_received{`},
+		},
+		{
+			accept: "text/plain; version=0.0.4",
+			want: []string{
+				"# TYPE zk_packets_received counter\n",
+				`zk_packets_received{zk_host="10.0.0.1:2181"} 5` + "\n",
+				"# TYPE zk_exporter_scrape_errors_total counter\n",
+			},
+			notWant: []string{"Deprecated", "zk_packets_received_total"},
+		},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		writeMetrics(w, r, metrics, nil, exposition{}, http.StatusOK, newTestOptions().log)
+
+		body := w.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: response doesn't contain %q:\n%s", tt.accept, want, body)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("%s: response contains %q:\n%s", tt.accept, notWant, body)
+			}
+		}
+	}
+}
+
+// filters match names of counters as reported, also in OpenMetrics format,
+// where they get '_total' suffix
+func TestWriteMetricsCounterTotalFilter(t *testing.T) {
+	hostLabels := []label{{"zk_host", "10.0.0.1:2181"}}
+	tests := []struct {
+		allow, deny string
+		want        bool
+	}{
+		{allow: "zk_packets_received", want: true},
+		{allow: "zk_up", want: false},
+		{deny: "zk_packets_received", want: false},
+		{deny: "zk_up", want: true},
+	}
+	formats := map[string]string{
+		"text/plain; version=0.0.4":                   "zk_packets_received{",
+		"application/openmetrics-text; version=0.0.1": "zk_packets_received_total{",
+	}
+	for _, tt := range tests {
+		filter, err := NewMetricFilter(tt.allow, tt.deny)
+		if err != nil {
+			t.Fatalf("NewMetricFilter: %v", err)
+		}
+		metrics := newMetricSet()
+		metrics.filter = filter
+		metrics.add("zk_packets_received", hostLabels, "5")
+
+		for accept, name := range formats {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			writeMetrics(w, r, metrics, nil, exposition{}, http.StatusOK, newTestOptions().log)
+			body := w.Body.String()
+			if strings.Contains(body, name) != tt.want {
+				t.Errorf("allow %q, deny %q, %s: %s exported = %v, want %v:\n%s", tt.allow, tt.deny, accept, name, !tt.want, tt.want, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const metricsProviderPath = "/metrics"
+
+// fetch metrics of zk PrometheusMetricsProvider (zk 3.6+) and add them to metrics
+// with 'zk_' prefix and host labels, e.g. 'znode_count' becomes 'zk_znode_count',
+// so that metrics which are reported by 'mntr' as well keep their names
+func scrapeMetricsProvider(ctx context.Context, options *Options, h string, hostLabels []label, metrics *metricSet) {
+	// connection isn't reused by the next scrape, so it's closed along with transport
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	if options.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(options.ProxyURL)
+	}
+	client := &http.Client{Timeout: options.Timeout, Transport: transport}
+
+	host, _, err := net.SplitHostPort(h)
+	if err != nil {
+		host = h
+	}
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(options.MetricsPort)), metricsProviderPath)
+
+	body, err := getMetricsProvider(ctx, options, client, url)
+	// canceled scrape doesn't tell whether server is up
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		options.countError(hostLabels, "metrics")
+		options.log.Warn("cannot get metrics from metrics provider", "zk_host", h, "error", err)
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			addHostDown(metrics, options, h, hostLabels, errorReason(&connError{err}))
+			return
+		}
+		metrics.add("zk_up", hostLabels, "1")
+		metrics.add("zk_mntr_scrape_success", hostLabels, "0")
+		addDownReason(metrics, hostLabels, errorReason(err))
+		return
+	}
+
+	metrics.add("zk_up", hostLabels, "1")
+	if err := parsePrometheusText(body, hostLabels, metrics); err != nil {
+		options.countError(hostLabels, "metrics")
+		options.log.Warn("cannot parse metrics provider response", "zk_host", h, "error", err)
+		metrics.add("zk_mntr_scrape_success", hostLabels, "0")
+		addDownReason(metrics, hostLabels, downReasonError)
+		return
+	}
+	metrics.add("zk_mntr_scrape_success", hostLabels, "1")
+}
+
+// get metrics provider response, non-200 status is treated as error
+func getMetricsProvider(ctx context.Context, options *Options, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	// gzip would be negotiated by transport, but only prometheus text format is parsed
+	req.Header.Set("Accept", contentTypeText)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	// read one byte over the limit to tell apart response of exactly max size
+	var body io.Reader = resp.Body
+	if options.MaxResponseSize > 0 {
+		body = io.LimitReader(resp.Body, options.MaxResponseSize+1)
+	}
+	res, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("cannot read response: %w", err)
+	}
+	if options.MaxResponseSize > 0 && int64(len(res)) > options.MaxResponseSize {
+		return "", fmt.Errorf("%w of %d bytes", errResponseTooLarge, options.MaxResponseSize)
+	}
+	return string(res), nil
+}
+
+// parse prometheus text format, e.g.
+//
+//	# HELP znode_count znode_count
+//	# TYPE znode_count gauge
+//	znode_count 5.0
+//	# TYPE local_write_committed_time_ms summary
+//	local_write_committed_time_ms{quantile="0.5",} NaN
+//	local_write_committed_time_ms_count 0.0
+//
+// names get 'zk_' prefix unless they have it, labels of source which clash with
+// host labels are dropped; malformed lines are skipped and reported as error
+func parsePrometheusText(text string, hostLabels []label, metrics *metricSet) error {
+	type sample struct {
+		name   string
+		labels []label
+		value  string
+	}
+	var samples []sample
+	types := map[string]string{}
+	helps := map[string]string{}
+	var err error
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	// lines of summaries with many labels may exceed default 64KiB
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[0] {
+			case "HELP":
+				helps[fields[1]] = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(fields[2])
+			case "TYPE":
+				types[fields[1]] = strings.TrimSpace(fields[2])
+			}
+			continue
+		}
+
+		// value follows the label set, which may contain spaces, or the name
+		end := strings.LastIndex(line, "}") + 1
+		if end == 0 {
+			end = strings.IndexAny(line, " \t")
+		}
+		if end <= 0 {
+			err = fmt.Errorf("sample without value %q", line)
+			continue
+		}
+		name, labels, perr := parseMetricKey(line[:end])
+		if perr != nil {
+			err = perr
+			continue
+		}
+		// timestamp, if any, is dropped: samples get time of exporter scrape
+		fields := strings.Fields(line[end:])
+		if len(fields) == 0 {
+			err = fmt.Errorf("sample without value %q", line)
+			continue
+		}
+		if _, perr := strconv.ParseFloat(fields[0], 64); perr != nil {
+			err = fmt.Errorf("malformed value of %q: %v", name, perr)
+			continue
+		}
+		samples = append(samples, sample{name, labels, fields[0]})
+	}
+	if serr := scanner.Err(); serr != nil {
+		return serr
+	}
+
+	prefixed := func(name string) string {
+		if strings.HasPrefix(name, "zk_") {
+			return name
+		}
+		return "zk_" + name
+	}
+
+	// families have to be typed before their samples are added
+	for name, typ := range types {
+		help := helps[name]
+		if help == "" || help == name {
+			help = fmt.Sprintf("Zookeeper metric %s.", prefixed(name))
+		}
+		metrics.setInfo(prefixed(name), metricInfo{typ, help})
+	}
+
+	for _, s := range samples {
+		labels := append([]label{}, hostLabels...)
+		for _, l := range s.labels {
+			if labelValue(hostLabels, l.name) == "" {
+				labels = append(labels, l)
+			}
+		}
+		if metrics.allowed(prefixed(s.name)) {
+			metrics.add(prefixed(s.name), labels, s.value)
+		}
+	}
+	return err
+}
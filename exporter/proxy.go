@@ -0,0 +1,238 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// errProxyTarget is returned when proxy itself works, but can't reach zk server
+var errProxyTarget = errors.New("proxy can't reach zk server")
+
+// proxyError is returned when proxy is unreachable or refuses the connection,
+// e.g. due to bad credentials, as opposed to zk server being unreachable
+type proxyError struct {
+	err error
+}
+
+func (e *proxyError) Error() string {
+	return "proxy: " + e.err.Error()
+}
+
+func (e *proxyError) Unwrap() error {
+	return e.err
+}
+
+// parse -proxy-url, supported schemes are socks5 and http (CONNECT method)
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5", "http":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected socks5 or http", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing proxy address in %q", raw)
+	}
+	return u, nil
+}
+
+// dial zk server at 'host:port' through proxy; hostname is resolved by proxy
+func dialProxy(ctx context.Context, proxy *url.URL, addr string, timeout, keepAlive time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, &proxyError{err}
+	}
+
+	// bound handshake with proxy by the same timeout as connection
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	tunnel := conn
+	if proxy.Scheme == "http" {
+		tunnel, err = httpConnect(conn, proxy, addr)
+	} else {
+		err = socks5Connect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		if errors.Is(err, errProxyTarget) {
+			return nil, err
+		}
+		return nil, &proxyError{err}
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// bufferedConn is a connection which data was partly read ahead into reader,
+// e.g. zk response sent by proxy along with CONNECT reply; reads drain it first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// establish tunnel with http CONNECT method, returned connection must be used
+// for the tunnel, since data following the reply may be read along with it
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	// reader buffers at least 16 bytes, so it may read past the reply
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, fmt.Errorf("%w: CONNECT %s: %s", errProxyTarget, addr, resp.Status)
+	default:
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// establish tunnel with socks5 CONNECT command, optionally authenticating
+// with username and password (RFC 1928, RFC 1929)
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// greeting with supported auth methods: no auth, and username/password if set
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("unexpected socks version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxy.User == nil {
+			return errors.New("socks5 proxy requires authentication")
+		}
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("socks5 username and password must be at most 255 bytes")
+		}
+		req := []byte{0x01, byte(len(user))}
+		req = append(req, user...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5 authentication failed")
+		}
+	default:
+		return errors.New("no acceptable socks5 authentication methods")
+	}
+
+	// connect request, ip addresses are sent as is and hostnames are resolved by proxy
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname %q is too long", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// reply: version, status, reserved, bound address type and address, port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	// network or host unreachable, connection refused and ttl expired
+	// mean that zk server is down, not proxy
+	switch header[1] {
+	case 0x00:
+	case 0x03, 0x04, 0x05, 0x06:
+		return fmt.Errorf("%w: socks5 connect to %s failed with code %d", errProxyTarget, addr, header[1])
+	default:
+		return fmt.Errorf("socks5 connect to %s failed with code %d", addr, header[1])
+	}
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("unexpected socks5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
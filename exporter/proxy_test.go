@@ -0,0 +1,206 @@
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// proxy sends zk response in the same packet as CONNECT reply, it must not be lost
+func TestHTTPConnectKeepsDataAfterReply(t *testing.T) {
+	client, proxy := net.Pipe()
+	go func() {
+		defer proxy.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(proxy)); err != nil {
+			return
+		}
+		proxy.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nimok"))
+	}()
+
+	conn, err := httpConnect(client, &url.URL{Scheme: "http", Host: "proxy:3128"}, "zk-0:2181")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("cannot read tunnel: %v", err)
+	}
+	if string(res) != "imok" {
+		t.Errorf("tunnel data = %q, want %q", res, "imok")
+	}
+}
+
+// fakeSocks5 is a minimal socks5 proxy (RFC 1928, RFC 1929) which supports
+// CONNECT command and, if user is set, username and password authentication
+type fakeSocks5 struct {
+	listener       net.Listener
+	user, password string
+}
+
+func newFakeSocks5(t *testing.T, user, password string) *fakeSocks5 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	p := &fakeSocks5{listener: l, user: user, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *fakeSocks5) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// greeting: version and auth methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+		return
+	}
+	if p.user == "" {
+		conn.Write([]byte{0x05, 0x00})
+	} else {
+		conn.Write([]byte{0x05, 0x02})
+		user, password, ok := readSocks5Auth(r)
+		if !ok || user != p.user || password != p.password {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	// connect request: version, command, reserved, address type, address and port
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 0x01:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		l, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		conn.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		// connection refused
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+// read username and password of RFC 1929 auth request
+func readSocks5Auth(r *bufio.Reader) (string, string, bool) {
+	var fields []string
+	if _, err := r.ReadByte(); err != nil {
+		return "", "", false
+	}
+	for i := 0; i < 2; i++ {
+		l, err := r.ReadByte()
+		if err != nil {
+			return "", "", false
+		}
+		field := make([]byte, l)
+		if _, err := io.ReadFull(r, field); err != nil {
+			return "", "", false
+		}
+		fields = append(fields, string(field))
+	}
+	return fields[0], fields[1], true
+}
+
+func TestScrapeThroughSocks5(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+	proxy := newFakeSocks5(t, "monitor", "secret")
+	defer proxy.listener.Close()
+
+	// address nothing listens at, proxy can't reach it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	down := l.Addr().String()
+	l.Close()
+	_, port, _ := net.SplitHostPort(z.listener.Addr().String())
+
+	tests := []struct {
+		name     string
+		host     string
+		password string
+		up       string
+		reason   string // reason of zk_connection_error, empty if zk server is up
+	}{
+		{"ip address", z.listener.Addr().String(), "secret", "1", ""},
+		{"hostname resolved by proxy", "localhost:" + port, "secret", "1", ""},
+		{"proxy can't reach zk server", down, "secret", "0", downReasonConnect},
+		{"rejected credentials", z.listener.Addr().String(), "wrong", "0", downReasonProxy},
+	}
+	for _, tt := range tests {
+		options := newTestOptions()
+		options.Hosts, options.Commands, options.Timeout = []string{tt.host}, []string{"mntr"}, 5*time.Second
+		options.ProxyURL = &url.URL{Scheme: "socks5", Host: proxy.listener.Addr().String(), User: url.UserPassword("monitor", tt.password)}
+		hostLabels := options.hostLabels(tt.host)
+
+		metrics := getMetrics(context.Background(), options)
+
+		if v, _ := metrics.value("zk_up", hostLabels); v != tt.up {
+			t.Errorf("%s: zk_up = %q, want %q", tt.name, v, tt.up)
+		}
+		if tt.up == "1" {
+			if v, _ := metrics.value("zk_znode_count", hostLabels); v != "42" {
+				t.Errorf("%s: zk_znode_count = %q, want 42", tt.name, v)
+			}
+		}
+		for _, reason := range []string{downReasonConnect, downReasonProxy} {
+			_, ok := metrics.value("zk_connection_error", append(hostLabels, label{"reason", reason}))
+			if want := reason == tt.reason; ok != want {
+				t.Errorf("%s: zk_connection_error with reason=%q added: %v, want %v", tt.name, reason, ok, want)
+			}
+		}
+	}
+}
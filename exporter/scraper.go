@@ -0,0 +1,217 @@
+package exporter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scraper runs scrapes of zk servers and keeps result of the last one
+type scraper struct {
+	options *Options
+
+	mu   sync.RWMutex
+	last *metricSet
+	// time of the last successful 'mntr' of every zk server, keyed by id of
+	// its zk_mntr_scrape_success series, kept across scrapes
+	lastSuccess map[string]time.Time
+	// number of scrapes in a row zk servers with exporter-wide state, e.g.
+	// errors counters, weren't scraped in, keyed by host key
+	missed map[string]int
+
+	// number of zk servers reachable during the last scrape, -1 until first scrape
+	hostsUp int64
+
+	// scrape in progress, which concurrent requests wait for instead of starting their own
+	flightMu sync.Mutex
+	flight   *sharedScrape
+}
+
+// sharedScrape is a scrape shared by concurrent requests, it's canceled
+// when all of them are done, e.g. their clients disconnected
+type sharedScrape struct {
+	done    chan struct{}
+	metrics *metricSet
+	cancel  context.CancelFunc
+	waiters int
+}
+
+func newScraper(options *Options) *scraper {
+	return &scraper{options: options, hostsUp: -1, lastSuccess: map[string]time.Time{}, missed: map[string]int{}}
+}
+
+// exporter-wide state of zk server is dropped once it isn't scraped for that many
+// scrapes in a row, e.g. it's removed from the cluster, so that series of servers
+// which come and go, e.g. pods, don't pile up; a few scrapes are tolerated, since
+// discovered or resolved servers may briefly drop out of the list
+const hostExpiryScrapes = 10
+
+// scrape zk servers and remember result; result of canceled scrape
+// is incomplete, so it isn't remembered
+func (s *scraper) scrape(ctx context.Context) *metricSet {
+	metrics := getMetrics(ctx, s.options)
+	metrics.add("zk_exporter_last_scrape_timestamp_seconds", nil, strconv.FormatInt(time.Now().Unix(), 10))
+	if ctx.Err() != nil {
+		return metrics
+	}
+
+	s.mu.Lock()
+	s.addLastSuccess(metrics)
+	s.expireHosts(metrics)
+	s.last = metrics
+	s.mu.Unlock()
+	atomic.StoreInt64(&s.hostsUp, int64(metrics.count("zk_up", "1")))
+
+	return metrics
+}
+
+// remember zk servers which 'mntr' succeeded and add time of the last success
+// of every scraped server, servers which never succeeded have no such series;
+// servers which are no longer scraped are forgotten; s.mu must be held
+func (s *scraper) addLastSuccess(metrics *metricSet) {
+	scraped := map[string]bool{}
+	for _, series := range metrics.sorted() {
+		if series.name != "zk_mntr_scrape_success" {
+			continue
+		}
+		id := series.id()
+		scraped[id] = true
+		if series.value == "1" {
+			s.lastSuccess[id] = metrics.timestamp
+		}
+		if last, ok := s.lastSuccess[id]; ok {
+			metrics.add("zk_last_successful_scrape_timestamp_seconds", series.labels, strconv.FormatInt(last.Unix(), 10))
+		}
+	}
+
+	for id := range s.lastSuccess {
+		if !scraped[id] {
+			delete(s.lastSuccess, id)
+		}
+	}
+}
+
+// drop exporter-wide state of zk servers, which haven't been scraped
+// for hostExpiryScrapes scrapes; s.mu must be held
+func (s *scraper) expireHosts(metrics *metricSet) {
+	scraped := map[string]bool{}
+	for _, series := range metrics.sorted() {
+		if series.name == "zk_up" {
+			scraped[hostKey(series.labels)] = true
+		}
+	}
+
+	state := s.options.state
+	hosts := hostsOf(state.scrapeErrors)
+	for key, labels := range hostsOf(state.commandDuration) {
+		hosts[key] = labels
+	}
+	for _, key := range state.breaker.keys() {
+		if _, ok := hosts[key]; !ok {
+			hosts[key] = nil
+		}
+	}
+	for key, labels := range hosts {
+		if scraped[key] {
+			delete(s.missed, key)
+			continue
+		}
+		s.missed[key]++
+		if s.missed[key] >= hostExpiryScrapes {
+			state.forgetHost(key, labels)
+			delete(s.missed, key)
+		}
+	}
+	// state of server is gone otherwise, e.g. breaker closed on success
+	for key := range s.missed {
+		if _, ok := hosts[key]; !ok {
+			delete(s.missed, key)
+		}
+	}
+}
+
+// join scrape in progress or start a new one, so that overlapping requests,
+// e.g. of several prometheus replicas, don't multiply load of zk servers;
+// returns empty metric set if ctx is done before scrape is finished
+func (s *scraper) scrapeShared(ctx context.Context) *metricSet {
+	s.flightMu.Lock()
+	f := s.flight
+	if f == nil {
+		// scrape outlives request which started it, as long as others wait for it
+		scrapeCtx, cancel := context.WithCancel(context.Background())
+		f = &sharedScrape{done: make(chan struct{}), cancel: cancel}
+		s.flight = f
+		go func() {
+			f.metrics = s.scrape(scrapeCtx)
+			s.flightMu.Lock()
+			if s.flight == f {
+				s.flight = nil
+			}
+			s.flightMu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	s.flightMu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.metrics
+	case <-ctx.Done():
+	}
+
+	// canceled scrape is incomplete, so following requests start a new one
+	s.flightMu.Lock()
+	f.waiters--
+	if f.waiters == 0 {
+		f.cancel()
+		if s.flight == f {
+			s.flight = nil
+		}
+	}
+	s.flightMu.Unlock()
+	return newMetricSet()
+}
+
+// run scrapes in background every interval until ctx is done; scrape which takes
+// longer than interval delays the next one, ticks missed meanwhile are dropped
+func (s *scraper) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.scrapeShared(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// metrics returns result of the last background scrape, or scrapes
+// zk servers right away when background scraping isn't enabled;
+// such scrape is shared with concurrent requests and is aborted
+// when ctx of all of them is done
+func (s *scraper) metrics(ctx context.Context) *metricSet {
+	if s.options.ScrapeInterval <= 0 {
+		return s.scrapeShared(ctx)
+	}
+
+	s.mu.RLock()
+	last := s.last
+	s.mu.RUnlock()
+
+	// first background scrape isn't finished yet, wait for it
+	if last == nil {
+		return s.scrapeShared(ctx)
+	}
+	return last
+}
+
+// number of zk servers reachable during the last scrape, -1 if there was no scrape yet
+func (s *scraper) reachableHosts() int64 {
+	return atomic.LoadInt64(&s.hostsUp)
+}
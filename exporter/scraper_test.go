@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpireHosts(t *testing.T) {
+	gone := []label{{"cluster", "expire"}, {"zk_host", "zk-0:2181"}}
+	kept := []label{{"cluster", "expire"}, {"zk_host", "zk-1:2181"}}
+	goneKey, keptKey := hostKey(gone), hostKey(kept)
+
+	newOptions := func() *Options {
+		options := &Options{Cluster: "expire", log: &leveledLogger{out: ioutil.Discard}}
+		options.initState()
+		return options
+	}
+	options, other := newOptions(), newOptions()
+	state := options.state
+	for _, l := range [][]label{gone, kept} {
+		options.countError(l, "mntr")
+		options.observeCommand(l, "mntr", 10*time.Millisecond)
+		state.breaker.record(hostKey(l), false)
+	}
+	// state of another exporter which scrapes the same server isn't touched
+	other.countError(gone, "mntr")
+
+	s := newScraper(options)
+	for i := 1; i <= hostExpiryScrapes; i++ {
+		metrics := newMetricSet()
+		metrics.add("zk_up", kept, "1")
+		s.expireHosts(metrics)
+
+		expired := i == hostExpiryScrapes
+		if _, ok := hostsOf(state.scrapeErrors)[goneKey]; ok == expired {
+			t.Fatalf("scrape %d: errors of %s expired: %v, want %v", i, goneKey, !expired, expired)
+		}
+		if _, ok := hostsOf(state.commandDuration)[goneKey]; ok == expired {
+			t.Fatalf("scrape %d: durations of %s expired: %v, want %v", i, goneKey, !expired, expired)
+		}
+		if containsString(state.breaker.keys(), goneKey) == expired {
+			t.Fatalf("scrape %d: breaker of %s expired: %v, want %v", i, goneKey, !expired, expired)
+		}
+	}
+
+	if hostsOf(state.scrapeErrors)[keptKey] == nil || hostsOf(state.commandDuration)[keptKey] == nil || !containsString(state.breaker.keys(), keptKey) {
+		t.Fatalf("state of scraped %s is expired", keptKey)
+	}
+	if _, ok := hostsOf(other.state.scrapeErrors)[goneKey]; !ok {
+		t.Fatalf("errors of %s are expired in another exporter", goneKey)
+	}
+	if len(s.missed) != 0 {
+		t.Fatalf("missed = %v, want empty", s.missed)
+	}
+}
+
+// fakeZookeeper is a zk server which counts connections and holds responses
+// until it's released, so that concurrent scrapes overlap
+type fakeZookeeper struct {
+	listener net.Listener
+	conns    int32
+	release  chan struct{}
+}
+
+func newFakeZookeeper(t *testing.T, release chan struct{}) *fakeZookeeper {
+	return newFakeZookeeperAt(t, "127.0.0.1:0", release)
+}
+
+// fake zk server listening at given address, e.g. of server which was down
+func newFakeZookeeperAt(t *testing.T, addr string, release chan struct{}) *fakeZookeeper {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	z := &fakeZookeeper{listener: l, release: release}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&z.conns, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Read(make([]byte, 4))
+				<-z.release
+				fmt.Fprint(conn, "zk_server_state\tfollower\nzk_znode_count\t42\n")
+			}(conn)
+		}
+	}()
+	return z
+}
+
+func TestScrapeSharedSingleConnection(t *testing.T) {
+	release := make(chan struct{})
+	servers := []*fakeZookeeper{newFakeZookeeper(t, release), newFakeZookeeper(t, release)}
+	var hosts []string
+	for _, z := range servers {
+		defer z.listener.Close()
+		hosts = append(hosts, z.listener.Addr().String())
+	}
+	options := newTestOptions()
+	options.Hosts, options.Commands, options.Timeout = hosts, []string{"mntr"}, 5*time.Second
+	s := newScraper(options)
+
+	const requests = 20
+	results := make(chan *metricSet, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			results <- s.scrapeShared(context.Background())
+		}()
+	}
+	// hold responses until every request joined the scrape
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.flightMu.Lock()
+		waiters := 0
+		if s.flight != nil {
+			waiters = s.flight.waiters
+		}
+		s.flightMu.Unlock()
+		if waiters == requests {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d requests joined the scrape", waiters, requests)
+		}
+	}
+	close(release)
+
+	for i := 0; i < requests; i++ {
+		metrics := <-results
+		if n := metrics.count("zk_up", "1"); n != len(hosts) {
+			t.Errorf("request %d: %d zk servers are up, want %d", i, n, len(hosts))
+		}
+	}
+	for _, z := range servers {
+		if n := atomic.LoadInt32(&z.conns); n != 1 {
+			t.Errorf("%s: %d connections, want 1", z.listener.Addr(), n)
+		}
+	}
+}
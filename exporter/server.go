@@ -0,0 +1,363 @@
+package exporter
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// prefix of -listen value which is a file descriptor of listening socket,
+// passed by systemd socket activation starting from 3
+const listenFDPrefix = "fd://"
+
+// page served at '/' to let humans check they've found the exporter
+const landingPage = `<html>
+<head><title>Zookeeper Exporter</title></head>
+<body>
+<h1>Zookeeper Exporter</h1>
+<p>%s</p>
+<p>Zookeeper servers: %d</p>
+%s</body>
+</html>
+`
+
+// parse comma separated list of metrics locations, e.g. to serve both
+// current and legacy paths while scrape configs are migrated
+func parseLocations(list string) ([]string, error) {
+	var locations []string
+	for _, location := range strings.Split(list, ",") {
+		location = strings.TrimSpace(location)
+		if !strings.HasPrefix(location, "/") {
+			return nil, fmt.Errorf("location %q must start with '/'", location)
+		}
+		if containsString(locations, location) {
+			return nil, fmt.Errorf("duplicate location %q", location)
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
+
+// serve zk metrics at chosen address and url
+func serveMetrics(exporter *Exporter) {
+	options := exporter.options
+	mux := newServeMux(exporter)
+
+	listener, err := listen(options.Listen)
+	if err != nil {
+		options.log.Fatal("cannot listen", "listen", options.Listen, "error", err)
+	}
+	server := &http.Server{Addr: options.Listen, Handler: mux, TLSConfig: options.ListenTLSConfig}
+
+	// on SIGTERM/SIGINT stop accepting new connections and let in-flight scrapes complete
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+		options.log.Info("shutting down exporter", "signal", sig, "timeout", options.ShutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			options.log.Warn("in-flight requests aren't completed within shutdown timeout", "error", err)
+		}
+		exporter.Close()
+		close(done)
+	}()
+
+	if options.ListenTLSCert != "" {
+		err = server.ServeTLS(listener, options.ListenTLSCert, options.ListenTLSKey)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != http.ErrServerClosed {
+		options.log.Fatal("shutting down exporter", "error", err)
+	}
+	<-done
+	options.log.Info("exporter stopped")
+}
+
+// handlers of metrics, probe, health and debug locations of exporter; metrics,
+// probe and debug locations are protected by http basic auth if it's configured
+func newServeMux(exporter *Exporter) *http.ServeMux {
+	options, scraper := exporter.options, exporter.scraper
+
+	handler := metricsHandler(exporter)
+
+	// multi-target pattern: prometheus passes zk server discovered by its service
+	// discovery in 'target' parameter, e.g. '/probe?target=10.0.0.1:2181'
+	probeHandler := func(w http.ResponseWriter, r *http.Request) {
+		// target may have scheme, e.g. 'tls://10.0.0.1:2281'
+		host := r.URL.Query().Get("target")
+		e := exposition{prefix: options.MetricPrefix, timestamps: options.Timestamps}
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				options.state.scrapePanics.Inc()
+				options.log.Error("recovered from panic in probe handler", "zk_host", host, "panic", rec, "stack", string(debug.Stack()))
+				http.Error(w, "probe failed", http.StatusInternalServerError)
+			}
+		}()
+
+		metrics, err := scrapeProbe(r.Context(), options, host)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'target' parameter, expected 'host:port': %v", err), http.StatusBadRequest)
+			return
+		}
+		if r.Context().Err() != nil {
+			options.log.Debug("probe canceled, client disconnected", "zk_host", host, "error", r.Context().Err())
+			return
+		}
+		writeMetrics(w, r, metrics, nil, e, http.StatusOK, options.log)
+	}
+
+	healthHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}
+
+	readyHandler := func(w http.ResponseWriter, r *http.Request) {
+		switch up := scraper.reachableHosts(); {
+		case up < 0:
+			http.Error(w, "not scraped yet", http.StatusServiceUnavailable)
+		case up == 0:
+			http.Error(w, "no zk servers reachable", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok\n"))
+		}
+	}
+
+	landingHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		hosts := len(options.GetHosts())
+		for _, c := range options.Clusters {
+			hosts += len(c.GetHosts())
+		}
+		var links strings.Builder
+		for _, location := range options.Locations {
+			l := html.EscapeString(location)
+			fmt.Fprintf(&links, "<p><a href=\"%s\">%s</a></p>\n", l, l)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, landingPage, html.EscapeString(versionString()), hosts, links.String())
+	}
+
+	mux := http.NewServeMux()
+	for _, location := range options.Locations {
+		mux.HandleFunc(location, accessLog(options, basicAuth(options, handler)))
+	}
+	if options.ProbeLocation != "" {
+		mux.HandleFunc(options.ProbeLocation, accessLog(options, basicAuth(options, probeHandler)))
+	}
+	mux.HandleFunc(options.HealthLocation, healthHandler)
+	mux.HandleFunc(options.ReadyLocation, readyHandler)
+	if !containsString(options.Locations, "/") {
+		mux.HandleFunc("/", landingHandler)
+	}
+
+	// raw responses of configured zk servers, e.g. '/debug/mntr?target=10.0.0.1:2181&command=srvr',
+	// commands configured for zk server are executed if 'command' isn't set
+	responsesHandler := func(w http.ResponseWriter, r *http.Request) {
+		host, err := normalizeHost(r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'target' parameter, expected 'host:port': %v", err), http.StatusBadRequest)
+			return
+		}
+		hostOptions, err := options.hostOptions(host)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'target' parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		if hostOptions == nil {
+			http.Error(w, fmt.Sprintf("%s isn't a configured zk server", host), http.StatusNotFound)
+			return
+		}
+		commands := hostOptions.hostCommands(host)
+		if list := r.URL.Query()["command"]; len(list) > 0 {
+			if commands, err = parseCommands(strings.Join(list, ",")); err != nil {
+				http.Error(w, fmt.Sprintf("invalid 'command' parameter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeResponses(r.Context(), w, hostOptions, host, commands)
+	}
+
+	// profiling endpoints expose internals, so they're protected the same way as metrics
+	if options.Pprof {
+		mux.HandleFunc("/debug/pprof/", basicAuth(options, pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", basicAuth(options, pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", basicAuth(options, pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", basicAuth(options, pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", basicAuth(options, pprof.Trace))
+	}
+	if options.DebugResponses {
+		mux.HandleFunc("/debug/mntr", accessLog(options, basicAuth(options, responsesHandler)))
+	}
+	return mux
+}
+
+// metricsHandler serves metrics of exporter's scrape; response has status 500 if
+// scrape panicked, or in strict mode if any zk server is down
+func metricsHandler(exporter *Exporter) http.HandlerFunc {
+	options, scraper := exporter.options, exporter.scraper
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metrics *metricSet
+		e := exposition{prefix: options.MetricPrefix, timestamps: options.Timestamps}
+
+		// weird zk response must not break scraping, serve whatever was gathered
+		defer func() {
+			if rec := recover(); rec != nil {
+				options.state.scrapePanics.Inc()
+				options.log.Error("recovered from panic in metrics handler", "panic", rec, "stack", string(debug.Stack()))
+				if metrics == nil {
+					metrics = newMetricSet()
+				}
+				writeMetrics(w, r, metrics, options.state, e, http.StatusInternalServerError, options.log)
+			}
+		}()
+
+		metrics = scraper.metrics(r.Context())
+		if r.Context().Err() != nil {
+			options.log.Debug("scrape canceled, client disconnected", "error", r.Context().Err())
+			return
+		}
+
+		// in strict mode any unreachable zk server fails the whole scrape, so that
+		// prometheus marks target down; metrics are still sent for debugging
+		status := http.StatusOK
+		if options.Strict && metrics.count("zk_up", "0") > 0 {
+			status = http.StatusInternalServerError
+		}
+		// scrape panicked, serve whatever was gathered
+		if metrics.isPanicked() {
+			status = http.StatusInternalServerError
+		}
+		writeMetrics(w, r, metrics, options.state, e, status, options.log)
+	}
+}
+
+// listen on 'host:port', or use listener passed by systemd socket activation as file
+// descriptor, e.g. 'fd://3', so that exporter doesn't need to bind port itself
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, listenFDPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	fd, err := strconv.Atoi(strings.TrimPrefix(addr, listenFDPrefix))
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("invalid file descriptor in %q, expected 'fd://3' or greater", addr)
+	}
+	f := os.NewFile(uintptr(fd), addr)
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// write metrics of the set, with exporter metrics of state unless it's nil, e.g.
+// for probe targets, rendered according to e in prometheus text or OpenMetrics
+// format, whichever client prefers according to Accept header, and gzipped if client
+// accepts it; metrics which can't be gathered, e.g. with invalid values, are logged
+// and skipped
+func writeMetrics(w http.ResponseWriter, r *http.Request, metrics *metricSet, state *exporterState, e exposition, status int, log *leveledLogger) {
+	// format is negotiated the same way as by promhttp handler
+	e.openMetrics = expfmt.NegotiateIncludingOpenMetrics(r.Header) == expfmt.FmtOpenMetrics
+	handler := promhttp.HandlerFor(metrics.gatherer(e, state), promhttp.HandlerOpts{
+		ErrorLog:          promLogger{log},
+		ErrorHandling:     promhttp.ContinueOnError,
+		EnableOpenMetrics: true,
+	})
+	handler.ServeHTTP(&statusWriter{ResponseWriter: w, status: status}, r)
+}
+
+// statusWriter sends given status instead of 200, e.g. to fail scrape in strict
+// mode, while response body is still written
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK {
+		status = w.status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// promLogger logs errors of gathering metrics, e.g. series with invalid values
+type promLogger struct {
+	log *leveledLogger
+}
+
+func (l promLogger) Println(v ...interface{}) {
+	l.log.Warn("failed to gather metrics", "error", strings.TrimSpace(fmt.Sprintln(v...)))
+}
+
+// statusRecorder remembers status code of response for access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// wrap handler with logging of every request, if -access-log is set; requests
+// rejected by basic auth are logged as well
+func accessLog(options *Options, next http.HandlerFunc) http.HandlerFunc {
+	if !options.AccessLog {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		options.log.Info("metrics request", "remote_addr", r.RemoteAddr, "path", r.URL.Path, "status", rec.status, "duration", time.Since(start))
+	}
+}
+
+// wrap handler with http basic auth check, if username is configured
+func basicAuth(options *Options, next http.HandlerFunc) http.HandlerFunc {
+	if options.AuthUsername == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		// compare both values to not leak which of them is wrong through timing
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(options.AuthUsername)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(options.AuthPassword)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="zookeeper-exporter", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
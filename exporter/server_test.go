@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// panic of command parser fails the scrape with status 500, metrics gathered
+// so far are served along with the panic counter
+func TestMetricsHandlerParserPanic(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+
+	parseMntr := commandParsers["mntr"]
+	commandParsers["mntr"] = func(*Options, string, []label, *metricSet) error {
+		panic("unexpected response")
+	}
+	defer func() { commandParsers["mntr"] = parseMntr }()
+
+	e, err := New(&Options{Hosts: []string{z.listener.Addr().String()}, Commands: []string{"mntr"}, LogOutput: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	w := httptest.NewRecorder()
+	metricsHandler(e)(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	for _, want := range []string{"zk_exporter_scrape_panics_total 1\n", "zk_exporter_up 1\n"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("response doesn't contain %q:\n%s", want, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandlerFormat(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+
+	e, err := New(&Options{Hosts: []string{z.listener.Addr().String()}, Commands: []string{"mntr"}, LogOutput: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tests := []struct {
+		accept      string
+		contentType string
+		eof         bool
+	}{
+		{"", "text/plain; version=0.0.4", false},
+		{"application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", "application/openmetrics-text; version=0.0.1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		metricsHandler(e)(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("accept %q: status = %d, want %d", tt.accept, w.Code, http.StatusOK)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("accept %q: Content-Type = %q, want %q", tt.accept, ct, tt.contentType)
+		}
+		if eof := strings.HasSuffix(w.Body.String(), "# EOF\n"); eof != tt.eof {
+			t.Errorf("accept %q: response ends with '# EOF': %v, want %v:\n%s", tt.accept, eof, tt.eof, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandlerGzip(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+	host := z.listener.Addr().String()
+
+	e, err := New(&Options{Hosts: []string{host}, Commands: []string{"mntr"}, LogOutput: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	want := `zk_up{zk_host="` + host + `"} 1` + "\n"
+	for _, gzipped := range []bool{false, true} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if gzipped {
+			r.Header.Set("Accept-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		metricsHandler(e)(w, r)
+
+		var body io.Reader = w.Body
+		if encoding := w.Header().Get("Content-Encoding"); gzipped {
+			if encoding != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+			}
+			if body, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatalf("response isn't gzipped: %v", err)
+			}
+		} else if encoding != "" {
+			t.Errorf("Content-Encoding = %q without Accept-Encoding, want none", encoding)
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatalf("gzip %v: cannot read response: %v", gzipped, err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("gzip %v: response doesn't contain %q:\n%s", gzipped, want, data)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	z := newFakeZookeeper(t, release)
+	defer z.listener.Close()
+
+	e, err := New(&Options{
+		Hosts:          []string{z.listener.Addr().String()},
+		Commands:       []string{"mntr"},
+		Locations:      []string{"/metrics"},
+		HealthLocation: "/healthz",
+		ReadyLocation:  "/ready",
+		AuthUsername:   "prometheus",
+		AuthPassword:   "secret",
+		LogOutput:      ioutil.Discard,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	mux := newServeMux(e)
+
+	tests := []struct {
+		name       string
+		path       string
+		user, pass string // no credentials if user is empty
+		status     int
+	}{
+		{"no credentials", "/metrics", "", "", http.StatusUnauthorized},
+		{"wrong password", "/metrics", "prometheus", "wrong", http.StatusUnauthorized},
+		{"wrong user", "/metrics", "admin", "secret", http.StatusUnauthorized},
+		{"valid credentials", "/metrics", "prometheus", "secret", http.StatusOK},
+		{"health without credentials", "/healthz", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if tt.status == http.StatusUnauthorized && !strings.HasPrefix(challenge, "Basic ") {
+			t.Errorf("%s: WWW-Authenticate = %q, want Basic challenge", tt.name, challenge)
+		}
+		if tt.status == http.StatusOK && challenge != "" {
+			t.Errorf("%s: WWW-Authenticate = %q, want none", tt.name, challenge)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// client certificate expiring sooner than that is logged as a warning on startup
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// tls versions accepted by -zk-tls-min-version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// build tls config for connections to zk servers, client certificate is omitted if
+// certFile and keyFile are empty, the one which expires soon is logged; minVersion and cipherSuites are left to go defaults if zero
+func newTLSConfig(log *leveledLogger, certFile, keyFile, caFile, serverName string, insecure bool, minVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load keypair %s, %s: %v", keyFile, certFile, err)
+		}
+
+		// expired client certificate is rejected by zk server, which looks like
+		// a connection failure, so fail early instead
+		expiry, err := certExpiry(cert)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %s: %v", certFile, err)
+		}
+		if left := time.Until(expiry); left < certExpiryWarning {
+			log.Warn("zk client certificate expires soon", "cert", certFile, "expiry", expiry.Format(time.RFC3339), "left", left.Round(time.Hour))
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read ca bundle %s: %v", caFile, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("can't find any certificates in ca bundle %s", caFile)
+		}
+	}
+
+	return config, nil
+}
+
+// return the earliest expiry time of certificate chain, along with error if any
+// certificate of the chain is expired or not valid yet; zero time if chain can't be parsed
+func certExpiry(cert tls.Certificate) (time.Time, error) {
+	var expiry time.Time
+	var err error
+	now := time.Now()
+	for _, der := range cert.Certificate {
+		c, perr := x509.ParseCertificate(der)
+		if perr != nil {
+			return time.Time{}, perr
+		}
+		if now.After(c.NotAfter) {
+			err = fmt.Errorf("certificate %q expired at %s", c.Subject.CommonName, c.NotAfter.Format(time.RFC3339))
+		} else if now.Before(c.NotBefore) {
+			err = fmt.Errorf("certificate %q isn't valid before %s", c.Subject.CommonName, c.NotBefore.Format(time.RFC3339))
+		}
+		if expiry.IsZero() || c.NotAfter.Before(expiry) {
+			expiry = c.NotAfter
+		}
+	}
+	if expiry.IsZero() {
+		return time.Time{}, fmt.Errorf("no certificates found")
+	}
+	return expiry, err
+}
+
+// parse -zk-tls-min-version, empty value means go default
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q, expected one of: 1.0, 1.1, 1.2, 1.3", s)
+	}
+	return v, nil
+}
+
+// parse comma separated list of cipher suite names, e.g. 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256';
+// suites which go considers insecure aren't accepted, empty list means go defaults
+func parseCipherSuites(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// build tls config for metrics server, which requires clients
+// to present certificate signed by given ca
+func newServerTLSConfig(clientCAFile string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read client ca bundle %s: %v", clientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("can't find any certificates in client ca bundle %s", clientCAFile)
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// return copy of tls config with server name set to zk hostname,
+// unless server name is configured explicitly
+func hostTLSConfig(config *tls.Config, host string) *tls.Config {
+	if config == nil || config.ServerName != "" {
+		return config
+	}
+
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+	}
+
+	hostConfig := config.Clone()
+	hostConfig.ServerName = name
+	return hostConfig
+}
@@ -0,0 +1,28 @@
+package exporter
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// set at build time with -ldflags, e.g.
+// pkg=github.com/dabealu/zookeeper-exporter/exporter
+// go build -ldflags "-X $pkg.version=v0.1.12 -X $pkg.commit=$(git rev-parse HEAD) -X $pkg.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("zookeeper-exporter %s (commit: %s, built: %s, %s)", version, commit, date, runtime.Version())
+}
+
+// add constant build info series
+func addBuildInfo(metrics *metricSet) {
+	metrics.add("zk_exporter_build_info", []label{
+		{"version", version},
+		{"commit", commit},
+		{"go_version", runtime.Version()},
+	}, "1")
+}